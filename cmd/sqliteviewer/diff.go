@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"sqliteviewer/internal/server"
+)
+
+// runDiff implements `sqliteviewer diff a.db b.db`. Like diff(1) it exits
+// with 0 when the schemas match, 1 when they differ and 2 on errors.
+func runDiff(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	format := fs.String("format", "text", "Output format: text, json or sql (the migration script only)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sqliteviewer diff [-format text|json|sql] a.db b.db")
+		fmt.Fprintln(fs.Output(), "Compares the schemas of two databases and prints a migration script that brings A to B.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	// Only stdout carries the diff, so check the files without logging
+	for _, path := range fs.Args() {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "cannot access database file: %v\n", err)
+			return 2
+		}
+		if info.IsDir() {
+			fmt.Fprintf(stderr, "cannot access database file: %s is a directory\n", path)
+			return 2
+		}
+	}
+
+	d, err := server.DiffDatabases(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(stderr, "diff failed: %v\n", err)
+		return 2
+	}
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			fmt.Fprintf(stderr, "write diff: %v\n", err)
+			return 2
+		}
+	case "sql":
+		fmt.Fprint(stdout, d.Script)
+	case "text":
+		writeDiffText(stdout, d)
+	default:
+		fmt.Fprintf(stderr, "unknown format %q\n", *format)
+		return 2
+	}
+	if d.Empty() {
+		return 0
+	}
+	return 1
+}
+
+var changeMarks = map[string]string{"added": "+", "removed": "-", "changed": "~"}
+
+func writeDiffText(w io.Writer, d *server.SchemaDiff) {
+	if d.Empty() {
+		fmt.Fprintln(w, "Schemas are identical.")
+		return
+	}
+	if len(d.Tables) > 0 {
+		fmt.Fprintln(w, "Tables:")
+		for _, t := range d.Tables {
+			fmt.Fprintf(w, "  %s %s (%s)\n", changeMarks[t.Change], t.Name, t.Strategy)
+			for _, col := range t.Columns {
+				switch col.Change {
+				case "added":
+					fmt.Fprintf(w, "      + %s %s\n", col.Name, describeColumn(col.To))
+				case "removed":
+					fmt.Fprintf(w, "      - %s %s\n", col.Name, describeColumn(col.From))
+				default:
+					fmt.Fprintf(w, "      ~ %s %s -> %s\n", col.Name, describeColumn(col.From), describeColumn(col.To))
+				}
+			}
+		}
+	}
+	for _, group := range []struct {
+		title   string
+		objects []server.ObjectDiff
+	}{{"Indexes", d.Indexes}, {"Triggers", d.Triggers}, {"Views", d.Views}} {
+		if len(group.objects) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s:\n", group.title)
+		for _, o := range group.objects {
+			fmt.Fprintf(w, "  %s %s on %s\n", changeMarks[o.Change], o.Name, o.Table)
+		}
+	}
+	if len(d.Warnings) > 0 {
+		fmt.Fprintln(w, "Warnings:")
+		for _, warning := range d.Warnings {
+			fmt.Fprintf(w, "  ! %s\n", warning)
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "-- Migration from A to B")
+	fmt.Fprint(w, d.Script)
+}
+
+func describeColumn(col *server.DiffColumn) string {
+	parts := []string{col.Type}
+	if col.Type == "" {
+		parts[0] = "(no type)"
+	}
+	if col.PrimaryKey > 0 {
+		parts = append(parts, "PRIMARY KEY")
+	}
+	if col.NotNull {
+		parts = append(parts, "NOT NULL")
+	}
+	if col.Default != "" {
+		parts = append(parts, "DEFAULT "+col.Default)
+	}
+	if col.Hidden > 1 {
+		parts = append(parts, "GENERATED")
+	}
+	return strings.Join(parts, " ")
+}
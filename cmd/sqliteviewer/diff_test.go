@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// createDB writes a database set up by schema into dir.
+func createDB(t *testing.T, dir, name, schema string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	// A URI keeps ? and # in the name part of the path
+	db, err := sql.Open("sqlite", "file:"+(&url.URL{Path: path}).EscapedPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunDiff(t *testing.T) {
+	const base = "CREATE TABLE t(id INTEGER PRIMARY KEY, a TEXT);"
+	tests := []struct {
+		name     string
+		schemaA  string
+		schemaB  string
+		fileA    string
+		args     []string
+		wantCode int
+		// wantOut and wantErr must appear in stdout and stderr
+		wantOut []string
+		wantErr string
+	}{
+		{
+			name:     "identical",
+			schemaA:  base,
+			schemaB:  base,
+			wantCode: 0,
+			wantOut:  []string{"Schemas are identical."},
+		},
+		{
+			name:     "added column as text",
+			schemaA:  base,
+			schemaB:  "CREATE TABLE t(id INTEGER PRIMARY KEY, a TEXT, b INTEGER NOT NULL DEFAULT 0);",
+			wantCode: 1,
+			wantOut:  []string{"~ t (add-column)", "+ b INTEGER NOT NULL DEFAULT 0", "ALTER TABLE"},
+		},
+		{
+			name:     "added index as json",
+			schemaA:  base,
+			schemaB:  base + "CREATE INDEX t_a ON t(a);",
+			args:     []string{"-format", "json"},
+			wantCode: 1,
+			wantOut:  []string{`"name": "t_a"`, `"change": "added"`},
+		},
+		{
+			name:     "removed table as sql",
+			schemaA:  base + "CREATE TABLE gone(x);",
+			schemaB:  base,
+			args:     []string{"-format", "sql"},
+			wantCode: 1,
+			wantOut:  []string{`DROP TABLE "gone"`},
+		},
+		{
+			name:     "path with URI characters",
+			schemaA:  base,
+			schemaB:  base,
+			fileA:    "a?mode=rw#x.db",
+			wantCode: 0,
+		},
+		{
+			name:     "unknown format",
+			schemaA:  base,
+			schemaB:  base,
+			args:     []string{"-format", "yaml"},
+			wantCode: 2,
+			wantErr:  `unknown format "yaml"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fileA := tt.fileA
+			if fileA == "" {
+				fileA = "a.db"
+			}
+			a := createDB(t, dir, fileA, tt.schemaA)
+			b := createDB(t, dir, "b.db", tt.schemaB)
+
+			var stdout, stderr bytes.Buffer
+			code := runDiff(append(tt.args, a, b), &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Fatalf("exit %d, want %d\nstdout: %s\nstderr: %s", code, tt.wantCode, &stdout, &stderr)
+			}
+			for _, want := range tt.wantOut {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("stdout lacks %q:\n%s", want, &stdout)
+				}
+			}
+			if tt.wantErr != "" && !strings.Contains(stderr.String(), tt.wantErr) {
+				t.Errorf("stderr lacks %q:\n%s", tt.wantErr, &stderr)
+			}
+		})
+	}
+}
+
+func TestRunDiffScriptMigrates(t *testing.T) {
+	dir := t.TempDir()
+	a := createDB(t, dir, "a.db", `
+CREATE TABLE t(id INTEGER PRIMARY KEY, a TEXT, old TEXT);
+CREATE INDEX t_old ON t(old);
+INSERT INTO t VALUES (1, 'x', 'y');`)
+	b := createDB(t, dir, "b.db", `
+CREATE TABLE t(id INTEGER PRIMARY KEY, a TEXT NOT NULL DEFAULT '', n INTEGER);
+CREATE TABLE u(x);
+CREATE VIEW v AS SELECT a FROM t;`)
+
+	var script, stderr bytes.Buffer
+	if code := runDiff([]string{"-format", "sql", a, b}, &script, &stderr); code != 1 {
+		t.Fatalf("exit %d, want 1: %s", code, &stderr)
+	}
+	db, err := sql.Open("sqlite", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(script.String()); err != nil {
+		t.Fatalf("apply script: %v\n%s", err, &script)
+	}
+	var value string
+	if err := db.QueryRow("SELECT a FROM v").Scan(&value); err != nil || value != "x" {
+		t.Errorf("row after migration = %q, %v; want x", value, err)
+	}
+	db.Close()
+
+	var out bytes.Buffer
+	if code := runDiff([]string{"-format", "json", a, b}, &out, &stderr); code != 0 {
+		t.Fatalf("exit %d after migration, want 0:\n%s", code, &out)
+	}
+	var d struct {
+		Tables []json.RawMessage `json:"tables"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil || len(d.Tables) != 0 {
+		t.Errorf("diff after migration: %s (%v)", &out, err)
+	}
+}
+
+func TestRunDiffArguments(t *testing.T) {
+	dir := t.TempDir()
+	a := createDB(t, dir, "a.db", "CREATE TABLE t(x);")
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "one file", args: []string{a}, wantErr: "Usage: sqliteviewer diff"},
+		{name: "missing file", args: []string{a, filepath.Join(dir, "missing.db")}, wantErr: "cannot access database file"},
+		{name: "directory", args: []string{a, dir}, wantErr: "is a directory"},
+		{name: "unknown flag", args: []string{"-x", a, a}, wantErr: "flag provided but not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runDiff(tt.args, &stdout, &stderr); code != 2 {
+				t.Errorf("exit %d, want 2", code)
+			}
+			if stdout.Len() != 0 {
+				t.Errorf("stdout not empty: %s", &stdout)
+			}
+			if !strings.Contains(stderr.String(), tt.wantErr) {
+				t.Errorf("stderr lacks %q:\n%s", tt.wantErr, &stderr)
+			}
+		})
+	}
+}
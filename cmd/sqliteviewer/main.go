@@ -11,9 +11,18 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiff(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	dbPath := flag.String("db", "", "Path to the SQLite file to inspect")
 	addr := flag.String("addr", ":8080", "Address for the HTTP server")
 	staticDir := flag.String("static", "", "Optional directory with custom frontend assets (defaults to embedded build)")
+	foreignKeys := flag.Bool("foreign-keys", false, "Enforce foreign key constraints on every connection")
+	snapshotDir := flag.String("snapshot-dir", "", "Optional directory for database snapshots")
+	snapshotInterval := flag.Duration("snapshot-interval", 0, "Take a snapshot at this interval (requires -snapshot-dir)")
+	snapshotKeep := flag.Int("snapshot-keep", 7, "Number of snapshots to retain (0 keeps all)")
+	maxRestoreSize := flag.Int64("max-restore-size", 1<<30, "Largest restore upload in bytes (0 for no limit)")
 	flag.Parse()
 
 	if *dbPath == "" {
@@ -38,7 +47,17 @@ func main() {
 		}
 	}
 
-	srv, err := server.New(*dbPath, staticFS)
+	if *snapshotInterval > 0 && *snapshotDir == "" {
+		log.Fatal("-snapshot-interval requires -snapshot-dir")
+	}
+
+	srv, err := server.New(*dbPath, staticFS, server.Options{
+		ForeignKeys:      *foreignKeys,
+		SnapshotDir:      *snapshotDir,
+		SnapshotInterval: *snapshotInterval,
+		SnapshotKeep:     *snapshotKeep,
+		MaxRestoreSize:   *maxRestoreSize,
+	})
 	if err != nil {
 		log.Fatalf("failed to initialize server: %v", err)
 	}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const snapshotTimeLayout = "20060102-150405"
+
+// vacuumInto writes a consistent copy of the live database to dest, which
+// must not exist yet. Unlike copying the file it is safe while other
+// connections write, including in WAL mode.
+func (s *Server) vacuumInto(dest string) error {
+	_, err := s.db.Exec("VACUUM INTO ?", dest)
+	return err
+}
+
+func (s *Server) backupBaseName() string {
+	return strings.TrimSuffix(filepath.Base(s.path), filepath.Ext(s.path))
+}
+
+func (s *Server) handleBackup(c *gin.Context) {
+	comp, ok := parseCompression(c, c.Query("compress"))
+	if !ok {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "sqliteviewer-backup-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "backup.db")
+	if err := s.vacuumInto(tmp); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	file, err := os.Open(tmp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	filename := fmt.Sprintf("%s-%s.db", s.backupBaseName(), time.Now().Format(snapshotTimeLayout))
+	if comp != nil {
+		setDownloadHeaders(c, filename, "application/vnd.sqlite3", comp)
+		cw, err := comp.newWriter(c.Writer)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := io.Copy(cw, file); err != nil {
+			c.Error(err)
+			return
+		}
+		if err := cw.Close(); err != nil {
+			c.Error(err)
+		}
+		return
+	}
+
+	if info, err := file.Stat(); err == nil {
+		c.Header("Content-Length", fmt.Sprintf("%d", info.Size()))
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Content-Type", "application/vnd.sqlite3")
+	if _, err := io.Copy(c.Writer, file); err != nil {
+		c.Error(err)
+	}
+}
+
+// takeSnapshot writes a timestamped copy into the snapshot directory and
+// removes the oldest ones beyond the retention count. Names carry
+// milliseconds so snapshots taken within the same second neither collide
+// nor lose their order.
+func (s *Server) takeSnapshot() (string, error) {
+	if err := os.MkdirAll(s.opts.SnapshotDir, 0o755); err != nil {
+		return "", err
+	}
+	var dest string
+	for t := time.Now(); ; t = t.Add(time.Millisecond) {
+		name := fmt.Sprintf("%s-%s.db", s.backupBaseName(), t.Format(snapshotTimeLayout+".000"))
+		dest = filepath.Join(s.opts.SnapshotDir, name)
+		if _, err := os.Stat(dest); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	if err := s.vacuumInto(dest); err != nil {
+		return "", err
+	}
+	return dest, s.pruneSnapshots()
+}
+
+type snapshotInfo struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// listSnapshots returns the snapshots of this database, newest first.
+func (s *Server) listSnapshots() ([]snapshotInfo, error) {
+	entries, err := os.ReadDir(s.opts.SnapshotDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prefix := s.backupBaseName() + "-"
+	var snapshots []snapshotInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".db") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshotInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	// Names embed a sortable timestamp
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name > snapshots[j].Name })
+	return snapshots, nil
+}
+
+func (s *Server) pruneSnapshots() error {
+	if s.opts.SnapshotKeep <= 0 {
+		return nil
+	}
+	snapshots, err := s.listSnapshots()
+	if err != nil {
+		return err
+	}
+	for i := s.opts.SnapshotKeep; i < len(snapshots); i++ {
+		if err := os.Remove(filepath.Join(s.opts.SnapshotDir, snapshots[i].Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSnapshots takes a snapshot every SnapshotInterval until stop is closed.
+func (s *Server) runSnapshots(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.opts.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.jobs.start("snapshot", "", func(j *job) (interface{}, error) {
+				path, err := s.takeSnapshot()
+				if err != nil {
+					log.Printf("scheduled snapshot failed: %v", err)
+					return nil, err
+				}
+				return gin.H{"path": path}, nil
+			})
+		}
+	}
+}
+
+func (s *Server) handleListSnapshots(c *gin.Context) {
+	if s.opts.SnapshotDir == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshots are not configured"})
+		return
+	}
+	snapshots, err := s.listSnapshots()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"dir":       s.opts.SnapshotDir,
+		"keep":      s.opts.SnapshotKeep,
+		"interval":  s.opts.SnapshotInterval.String(),
+		"snapshots": snapshots,
+	})
+}
+
+func (s *Server) handleCreateSnapshot(c *gin.Context) {
+	if s.opts.SnapshotDir == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshots are not configured"})
+		return
+	}
+	j := s.jobs.start("snapshot", "", func(j *job) (interface{}, error) {
+		path, err := s.takeSnapshot()
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"path": path}, nil
+	})
+	c.JSON(http.StatusAccepted, j)
+}
+
+func (s *Server) handleDownloadSnapshot(c *gin.Context) {
+	if s.opts.SnapshotDir == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshots are not configured"})
+		return
+	}
+	name := c.Param("name")
+	path, ok := s.snapshotPath(c, name)
+	if !ok {
+		return
+	}
+	c.FileAttachment(path, name)
+}
+
+// snapshotPath resolves a snapshot name from a request, responding 400 or
+// 404 if it does not name an existing snapshot.
+func (s *Server) snapshotPath(c *gin.Context, name string) (string, bool) {
+	if name != filepath.Base(name) || !strings.HasPrefix(name, s.backupBaseName()+"-") || !strings.HasSuffix(name, ".db") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid snapshot name"})
+		return "", false
+	}
+	path := filepath.Join(s.opts.SnapshotDir, name)
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshot not found"})
+		return "", false
+	}
+	return path, true
+}
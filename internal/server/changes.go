@@ -0,0 +1,337 @@
+package server
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionCookie  = "sqliteviewer_session"
+	sessionHeader  = "X-Session-ID"
+	maxUndoEntries = 100
+	// Clients that never send their session back, such as scripts, get a
+	// new history on every write; these bound what they leave behind.
+	maxSessions     = 1000
+	sessionIdleTime = 24 * time.Hour
+)
+
+// rowChange records one row-level write together with the row state before
+// and after it. A nil state means the row did not exist.
+type rowChange struct {
+	ID     int64
+	Table  string
+	RowID  int64
+	Op     string
+	Time   time.Time
+	Before *rowSnapshot
+	After  *rowSnapshot
+	// applying is set while an undo or redo of the change runs outside
+	// the log's lock.
+	applying bool
+}
+
+func (ch *rowChange) view() gin.H {
+	view := gin.H{
+		"id":    ch.ID,
+		"table": ch.Table,
+		"rowid": ch.RowID,
+		"op":    ch.Op,
+		"time":  ch.Time,
+	}
+	if ch.Before != nil {
+		view["before"] = ch.Before.row()
+	}
+	if ch.After != nil {
+		view["after"] = ch.After.row()
+	}
+	return view
+}
+
+type changeStack struct {
+	undo     []*rowChange
+	redo     []*rowChange
+	lastUsed time.Time
+}
+
+// changeLog keeps an undo and a redo stack per client session.
+type changeLog struct {
+	mu       sync.Mutex
+	nextID   int64
+	sessions map[string]*changeStack
+}
+
+func newChangeLog() *changeLog {
+	return &changeLog{sessions: map[string]*changeStack{}}
+}
+
+func (l *changeLog) stack(session string) *changeStack {
+	st, ok := l.sessions[session]
+	if !ok {
+		l.evict()
+		st = &changeStack{}
+		l.sessions[session] = st
+	}
+	st.lastUsed = time.Now()
+	return st
+}
+
+// evict drops idle sessions and, while the log is still full, the least
+// recently used one, making room for a new session.
+func (l *changeLog) evict() {
+	var oldest string
+	for id, st := range l.sessions {
+		if time.Since(st.lastUsed) > sessionIdleTime {
+			delete(l.sessions, id)
+			continue
+		}
+		if oldest == "" || st.lastUsed.Before(l.sessions[oldest].lastUsed) {
+			oldest = id
+		}
+	}
+	if len(l.sessions) >= maxSessions {
+		delete(l.sessions, oldest)
+	}
+}
+
+// removeChange takes ch out of list, wherever later changes have left it.
+func removeChange(list *[]*rowChange, ch *rowChange) {
+	for i, c := range *list {
+		if c == ch {
+			*list = append((*list)[:i], (*list)[i+1:]...)
+			return
+		}
+	}
+}
+
+// record pushes a new change and discards the session's redo history.
+func (l *changeLog) record(session, table, op string, rowid int64, before, after *rowSnapshot) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextID++
+	st := l.stack(session)
+	st.undo = append(st.undo, &rowChange{
+		ID:     l.nextID,
+		Table:  table,
+		RowID:  rowid,
+		Op:     op,
+		Time:   time.Now(),
+		Before: before,
+		After:  after,
+	})
+	if len(st.undo) > maxUndoEntries {
+		st.undo = st.undo[len(st.undo)-maxUndoEntries:]
+	}
+	st.redo = nil
+}
+
+// sessionID identifies the client for undo history, issuing a cookie on
+// first use. API clients may send their own id in the X-Session-ID header.
+func sessionID(c *gin.Context) string {
+	if id := strings.TrimSpace(c.GetHeader(sessionHeader)); id != "" {
+		return id
+	}
+	if id, err := c.Cookie(sessionCookie); err == nil && id != "" {
+		return id
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "default"
+	}
+	id := hex.EncodeToString(buf)
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(sessionCookie, id, 0, "/", "", false, true)
+	return id
+}
+
+var errRowConflict = errors.New("row has been modified since the change")
+
+// transitionRow moves a row from the expected state to the target state
+// inside tx and returns the resulting state. If the row no longer matches
+// expected, it returns errRowConflict along with the current state.
+func transitionRow(tx *sql.Tx, table string, rowid int64, expected, target *rowSnapshot) (*rowSnapshot, error) {
+	current, err := loadRowSnapshot(tx, table, rowid)
+	if err != nil && !errors.Is(err, errRowNotFound) {
+		return nil, err
+	}
+	switch {
+	case expected == nil && current != nil:
+		return current, errRowConflict
+	case expected != nil && current == nil:
+		return nil, errRowConflict
+	case expected != nil && current.version != expected.version:
+		return current, errRowConflict
+	}
+
+	// Generated columns are computed from the others and cannot be written
+	writable, _, err := insertableColumns(tx, table)
+	if err != nil {
+		return nil, err
+	}
+	isWritable := make(map[string]bool, len(writable))
+	for _, col := range writable {
+		isWritable[col] = true
+	}
+
+	switch {
+	case target == nil:
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE rowid = ?", QuoteIdentifier(table)), rowid)
+	case current == nil:
+		cols := []string{"rowid"}
+		placeholders := []string{"?"}
+		values := []interface{}{rowid}
+		for i, col := range target.columns {
+			if !isWritable[col] {
+				continue
+			}
+			cols = append(cols, QuoteIdentifier(col))
+			placeholders = append(placeholders, "?")
+			values = append(values, target.values[i])
+		}
+		_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			QuoteIdentifier(table),
+			strings.Join(cols, ", "),
+			strings.Join(placeholders, ", "),
+		), values...)
+	default:
+		var setClauses []string
+		var values []interface{}
+		for i, col := range target.columns {
+			if !isWritable[col] {
+				continue
+			}
+			setClauses = append(setClauses, fmt.Sprintf("%s = ?", QuoteIdentifier(col)))
+			values = append(values, target.values[i])
+		}
+		values = append(values, rowid)
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE rowid = ?", QuoteIdentifier(table), strings.Join(setClauses, ", ")), values...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, nil
+	}
+	return loadRowSnapshot(tx, table, rowid)
+}
+
+func (s *Server) handleListChanges(c *gin.Context) {
+	session := sessionID(c)
+	s.changes.mu.Lock()
+	defer s.changes.mu.Unlock()
+
+	st := s.changes.stack(session)
+	undo := make([]gin.H, 0, len(st.undo))
+	for i := len(st.undo) - 1; i >= 0; i-- {
+		undo = append(undo, st.undo[i].view())
+	}
+	redo := make([]gin.H, 0, len(st.redo))
+	for i := len(st.redo) - 1; i >= 0; i-- {
+		redo = append(redo, st.redo[i].view())
+	}
+	c.JSON(http.StatusOK, gin.H{"undo": undo, "redo": redo})
+}
+
+func (s *Server) handleUndoChange(c *gin.Context) {
+	s.replayChange(c, true)
+}
+
+func (s *Server) handleRedoChange(c *gin.Context) {
+	s.replayChange(c, false)
+}
+
+// replayChange reverts the newest undo entry or reapplies the newest redo
+// entry, moving it to the opposite stack on success. With discard=true the
+// entry is dropped without being applied, so one that keeps failing does
+// not block the entries below it. The write runs without holding the log's
+// lock; other writes meanwhile are caught by the row version check.
+func (s *Server) replayChange(c *gin.Context, undo bool) {
+	session := sessionID(c)
+	s.changes.mu.Lock()
+	st := s.changes.stack(session)
+	from, to := &st.undo, &st.redo
+	if !undo {
+		from, to = &st.redo, &st.undo
+	}
+	if len(*from) == 0 {
+		s.changes.mu.Unlock()
+		if undo {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to undo"})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to redo"})
+		}
+		return
+	}
+	ch := (*from)[len(*from)-1]
+	if ch.applying {
+		s.changes.mu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "change is already being applied", "change": ch.view()})
+		return
+	}
+	if discard, _ := strconv.ParseBool(c.DefaultQuery("discard", "false")); discard {
+		*from = (*from)[:len(*from)-1]
+		s.changes.mu.Unlock()
+		c.JSON(http.StatusOK, gin.H{"status": "discarded", "change": ch.view()})
+		return
+	}
+	expected, target := ch.After, ch.Before
+	if !undo {
+		expected, target = ch.Before, ch.After
+	}
+	ch.applying = true
+	s.changes.mu.Unlock()
+
+	result, err := s.applyChange(ch, expected, target)
+
+	s.changes.mu.Lock()
+	defer s.changes.mu.Unlock()
+	ch.applying = false
+	if errors.Is(err, errRowConflict) {
+		resp := gin.H{"error": err.Error(), "change": ch.view(), "row": nil}
+		if result != nil {
+			resp["row"] = result.row()
+		}
+		c.JSON(http.StatusConflict, resp)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "change": ch.view()})
+		return
+	}
+
+	// Keep the state actually written so the opposite operation checks
+	// against it.
+	if undo {
+		ch.Before = result
+	} else {
+		ch.After = result
+	}
+	removeChange(from, ch)
+	*to = append(*to, ch)
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "change": ch.view()})
+}
+
+// applyChange moves the row of ch from expected to target in its own
+// transaction.
+func (s *Server) applyChange(ch *rowChange, expected, target *rowSnapshot) (*rowSnapshot, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := transitionRow(tx, ch.Table, ch.RowID, expected, target)
+	if err != nil {
+		return result, err
+	}
+	return result, tx.Commit()
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestChangeLogEvictsSessions(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions int
+		idle     string
+		wantGone string
+		wantLen  int
+	}{
+		{name: "under the cap", sessions: 10, wantLen: 11},
+		{name: "full drops least recently used", sessions: maxSessions, wantGone: "s0", wantLen: maxSessions},
+		{name: "idle session dropped", sessions: 10, idle: "s3", wantGone: "s3", wantLen: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newChangeLog()
+			start := time.Now().Add(-time.Hour)
+			for i := 0; i < tt.sessions; i++ {
+				id := fmt.Sprintf("s%d", i)
+				l.stack(id).lastUsed = start.Add(time.Duration(i) * time.Second)
+			}
+			if tt.idle != "" {
+				l.sessions[tt.idle].lastUsed = time.Now().Add(-2 * sessionIdleTime)
+			}
+			l.record("new", "t", "insert", 1, nil, nil)
+
+			if len(l.sessions) != tt.wantLen {
+				t.Errorf("sessions = %d, want %d", len(l.sessions), tt.wantLen)
+			}
+			if _, ok := l.sessions["new"]; !ok {
+				t.Error("new session missing")
+			}
+			if _, ok := l.sessions[tt.wantGone]; tt.wantGone != "" && ok {
+				t.Errorf("session %s kept", tt.wantGone)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type foreignKeyViolation struct {
+	Table  string                 `json:"table"`
+	RowID  *int64                 `json:"rowid"`
+	Parent string                 `json:"parent"`
+	FKID   int                    `json:"fkid"`
+	From   []string               `json:"from"`
+	To     []string               `json:"to"`
+	Row    map[string]interface{} `json:"row"`
+}
+
+func (s *Server) handleCheckForeignKeys(c *gin.Context) {
+	table := c.Query("table")
+	query := "PRAGMA foreign_key_check"
+	if table != "" {
+		if !IsSafeIdentifier(table) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid table name"})
+			return
+		}
+		query = fmt.Sprintf("PRAGMA foreign_key_check(%s)", QuoteIdentifier(table))
+	}
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var violations []foreignKeyViolation
+	for rows.Next() {
+		var v foreignKeyViolation
+		var rowid sql.NullInt64
+		if err := rows.Scan(&v.Table, &rowid, &v.Parent, &v.FKID); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if rowid.Valid {
+			v.RowID = &rowid.Int64
+		}
+		violations = append(violations, v)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Attach the offending columns and row so clients can jump straight to it
+	keysByTable := map[string][]foreignKey{}
+	for i := range violations {
+		v := &violations[i]
+		keys, ok := keysByTable[v.Table]
+		if !ok {
+			keys, err = s.foreignKeys(v.Table)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			keysByTable[v.Table] = keys
+		}
+		for _, fk := range keys {
+			if fk.ID == v.FKID {
+				v.From, v.To = fk.From, fk.To
+				break
+			}
+		}
+		if v.RowID != nil {
+			if row, err := loadRow(s.db, v.Table, *v.RowID); err == nil {
+				v.Row = row
+			}
+		}
+	}
+
+	var enforced bool
+	if err := s.db.QueryRow("PRAGMA foreign_keys").Scan(&enforced); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enforced":   enforced,
+		"count":      len(violations),
+		"violations": violations,
+	})
+}
+
+func (s *Server) handleCheckDatabase(c *gin.Context) {
+	pragma := "integrity_check"
+	if c.DefaultQuery("mode", "full") == "quick" {
+		pragma = "quick_check"
+	}
+
+	messages, err := s.runIntegrityCheck(pragma)
+	if err != nil {
+		// A badly damaged file fails the check outright; report that as its result
+		messages = append(messages, err.Error())
+	}
+
+	var pageSize, pageCount, freelistCount, autoVacuum int64
+	var journalMode, encoding, version string
+	settings := []struct {
+		query string
+		dest  interface{}
+	}{
+		{"PRAGMA page_size", &pageSize},
+		{"PRAGMA page_count", &pageCount},
+		{"PRAGMA freelist_count", &freelistCount},
+		{"PRAGMA journal_mode", &journalMode},
+		{"PRAGMA auto_vacuum", &autoVacuum},
+		{"PRAGMA encoding", &encoding},
+		{"SELECT sqlite_version()", &version},
+	}
+	for _, setting := range settings {
+		if err := s.db.QueryRow(setting.query).Scan(setting.dest); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("%s: %v", setting.query, err)})
+			return
+		}
+	}
+	autoVacuumModes := map[int64]string{0: "none", 1: "full", 2: "incremental"}
+
+	c.JSON(http.StatusOK, gin.H{
+		"check":         pragma,
+		"ok":            len(messages) == 1 && messages[0] == "ok",
+		"messages":      messages,
+		"pageSize":      pageSize,
+		"pageCount":     pageCount,
+		"freelistCount": freelistCount,
+		"sizeBytes":     pageSize * pageCount,
+		"journalMode":   journalMode,
+		"autoVacuum":    autoVacuumModes[autoVacuum],
+		"encoding":      encoding,
+		"sqliteVersion": version,
+	})
+}
+
+func (s *Server) runIntegrityCheck(pragma string) ([]string, error) {
+	rows, err := s.db.Query("PRAGMA " + pragma)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
@@ -0,0 +1,768 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SchemaDiff lists how the schema of database B differs from A, and the
+// script that migrates A to B. Objects that match are left out.
+type SchemaDiff struct {
+	Tables   []TableDiff  `json:"tables"`
+	Indexes  []ObjectDiff `json:"indexes"`
+	Triggers []ObjectDiff `json:"triggers"`
+	Views    []ObjectDiff `json:"views"`
+	// Warnings name changes the script cannot make without losing data.
+	Warnings []string `json:"warnings"`
+	Script   string   `json:"script"`
+}
+
+// Empty reports whether the schemas match.
+func (d *SchemaDiff) Empty() bool {
+	return len(d.Tables) == 0 && len(d.Indexes) == 0 && len(d.Triggers) == 0 && len(d.Views) == 0
+}
+
+// TableDiff describes a table that was added, removed or changed.
+type TableDiff struct {
+	Name string `json:"name"`
+	// Change is added, removed or changed.
+	Change string `json:"change"`
+	// Strategy is how the script applies the change: create, drop,
+	// add-column, rebuild, or recreate for virtual tables.
+	Strategy string       `json:"strategy"`
+	From     string       `json:"from,omitempty"`
+	To       string       `json:"to,omitempty"`
+	Columns  []ColumnDiff `json:"columns,omitempty"`
+}
+
+// ColumnDiff describes a column that was added, removed or changed, as
+// PRAGMA table_xinfo reports it. A renamed column shows as removed and
+// added.
+type ColumnDiff struct {
+	Name   string      `json:"name"`
+	Change string      `json:"change"`
+	From   *DiffColumn `json:"from,omitempty"`
+	To     *DiffColumn `json:"to,omitempty"`
+}
+
+// DiffColumn is a row of PRAGMA table_xinfo.
+type DiffColumn struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	NotNull bool   `json:"notnull"`
+	Default string `json:"default,omitempty"`
+	// PrimaryKey is the column's position in the primary key.
+	PrimaryKey int `json:"pk"`
+	// Hidden is 2 or 3 for generated columns.
+	Hidden int `json:"hidden,omitempty"`
+}
+
+// ObjectDiff describes an index, trigger or view that was added, removed
+// or changed.
+type ObjectDiff struct {
+	Name   string `json:"name"`
+	Table  string `json:"table"`
+	Change string `json:"change"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// diffObject is a row of sqlite_schema.
+type diffObject struct {
+	Type, Name, Table, SQL string
+}
+
+// schemaTable is a table with its definition split into parts that can
+// be compared.
+type schemaTable struct {
+	diffObject
+	Virtual      bool
+	WithoutRowid bool
+	Columns      []DiffColumn
+	// Defs maps lowercased column names to their normalized definitions.
+	Defs map[string]string
+	// Constraints and Options are the normalized table constraints and
+	// the options after the closing parenthesis.
+	Constraints []string
+	Options     string
+}
+
+func (t *schemaTable) column(name string) *DiffColumn {
+	for i := range t.Columns {
+		if strings.EqualFold(t.Columns[i].Name, name) {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// hasRowidAlias reports whether the table has an INTEGER PRIMARY KEY,
+// which is the rowid itself.
+func (t *schemaTable) hasRowidAlias() bool {
+	if t.WithoutRowid {
+		return false
+	}
+	var key []DiffColumn
+	for _, col := range t.Columns {
+		if col.PrimaryKey > 0 {
+			key = append(key, col)
+		}
+	}
+	return len(key) == 1 && strings.EqualFold(key[0].Type, "INTEGER")
+}
+
+// dbSchema holds the objects of a database in creation order.
+type dbSchema struct {
+	Objects []diffObject
+	Tables  map[string]*schemaTable
+}
+
+func (sc *dbSchema) object(typ, name string) *diffObject {
+	for i := range sc.Objects {
+		if sc.Objects[i].Type == typ && strings.EqualFold(sc.Objects[i].Name, name) {
+			return &sc.Objects[i]
+		}
+	}
+	return nil
+}
+
+// DiffDatabases compares the schemas of the database files at pathA and
+// pathB, which are opened read-only.
+func DiffDatabases(pathA, pathB string) (*SchemaDiff, error) {
+	var dbs [2]*sql.DB
+	for i, path := range []string{pathA, pathB} {
+		db, err := sql.Open("sqlite", readOnlyDSN(path))
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", path, err)
+		}
+		defer db.Close()
+		if err := db.Ping(); err != nil {
+			return nil, fmt.Errorf("open %s: %w", path, err)
+		}
+		dbs[i] = db
+	}
+	return diffSchemas(dbs[0], dbs[1])
+}
+
+// readOnlyDSN opens path read-only through a file: URI. The path is
+// escaped, since ? and # would otherwise start the query or fragment.
+func readOnlyDSN(path string) string {
+	return "file:" + (&url.URL{Path: path}).EscapedPath() + "?mode=ro"
+}
+
+// handleDiff compares the open database with an uploaded database or SQL
+// dump, or with a snapshot. With direction=to, the default, the script
+// migrates the open database to the other one; direction=from reverses it.
+func (s *Server) handleDiff(c *gin.Context) {
+	direction := c.DefaultPostForm("direction", "to")
+	if direction != "to" && direction != "from" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "direction must be to or from"})
+		return
+	}
+
+	var path string
+	if name := c.PostForm("snapshot"); name != "" {
+		if s.opts.SnapshotDir == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "snapshots are not configured"})
+			return
+		}
+		var ok bool
+		if path, ok = s.snapshotPath(c, name); !ok {
+			return
+		}
+	} else {
+		upload, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing file upload or snapshot name"})
+			return
+		}
+		staged, err := os.CreateTemp("", "sqliteviewer-diff-*.db")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		path = staged.Name()
+		staged.Close()
+		defer os.Remove(path)
+		if _, err := stageUpload(upload, path); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	other, err := sql.Open("sqlite", readOnlyDSN(path))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer other.Close()
+	var a, b rowQuerier = s.db, other
+	if direction == "from" {
+		a, b = b, a
+	}
+	d, err := diffSchemas(a, b)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+// diffSchemas compares the main schemas of a and b.
+func diffSchemas(a, b rowQuerier) (*SchemaDiff, error) {
+	from, err := loadSchema(a)
+	if err != nil {
+		return nil, fmt.Errorf("read schema of A: %w", err)
+	}
+	to, err := loadSchema(b)
+	if err != nil {
+		return nil, fmt.Errorf("read schema of B: %w", err)
+	}
+	return newSchemaDiff(from, to), nil
+}
+
+// loadSchema reads sqlite_schema and the columns of every table. Internal
+// objects and the shadow tables of virtual tables are left out, since
+// SQLite creates them itself.
+func loadSchema(q rowQuerier) (*dbSchema, error) {
+	sc := &dbSchema{Tables: map[string]*schemaTable{}}
+	rows, err := q.Query(`
+		SELECT m.type, m.name, m.tbl_name, m.sql, coalesce(l.type, ''), coalesce(l.wr, 0)
+		FROM sqlite_schema AS m
+		LEFT JOIN pragma_table_list AS l ON l.schema = 'main' AND l.name = m.name
+		WHERE m.name NOT LIKE 'sqlite_%' AND m.sql IS NOT NULL
+		ORDER BY m.rowid`)
+	if err != nil {
+		return nil, err
+	}
+	var tables []*schemaTable
+	shadows := map[string]bool{}
+	for rows.Next() {
+		var o diffObject
+		var listType string
+		var wr bool
+		if err := rows.Scan(&o.Type, &o.Name, &o.Table, &o.SQL, &listType, &wr); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if listType == "shadow" {
+			shadows[strings.ToLower(o.Name)] = true
+			continue
+		}
+		// Indexes and triggers of shadow tables belong to the module too
+		if shadows[strings.ToLower(o.Table)] {
+			continue
+		}
+		sc.Objects = append(sc.Objects, o)
+		if o.Type == "table" {
+			tables = append(tables, &schemaTable{diffObject: o, Virtual: listType == "virtual", WithoutRowid: wr})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, t := range tables {
+		if t.Columns, err = diffColumns(q, t.Name); err != nil {
+			return nil, err
+		}
+		if !t.Virtual {
+			t.Defs, t.Constraints, t.Options = splitTableSQL(t.SQL)
+		}
+		sc.Tables[strings.ToLower(t.Name)] = t
+	}
+	return sc, nil
+}
+
+func diffColumns(q rowQuerier, table string) ([]DiffColumn, error) {
+	rows, err := q.Query(`SELECT name, type, "notnull", dflt_value, pk, hidden FROM pragma_table_xinfo(?) ORDER BY cid`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []DiffColumn
+	for rows.Next() {
+		var col DiffColumn
+		var dflt sql.NullString
+		if err := rows.Scan(&col.Name, &col.Type, &col.NotNull, &dflt, &col.PrimaryKey, &col.Hidden); err != nil {
+			return nil, err
+		}
+		// hidden is 1 for the hidden columns of virtual tables
+		if col.Hidden == 1 {
+			continue
+		}
+		col.Default = dflt.String
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+const sqlNamePattern = `(?:"(?:[^"]|"")*"|\[[^\]]*\]|` + "`(?:[^`]|``)*`" + `|'(?:[^']|'')*'|[\w$]+)`
+
+var (
+	createTablePattern = regexp.MustCompile(`(?is)^\s*CREATE\s+(?:TEMP\s+|TEMPORARY\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?(?:` + sqlNamePattern + `\s*\.\s*)?(` + sqlNamePattern + `)\s*\(`)
+	leadingNamePattern = regexp.MustCompile(`^` + sqlNamePattern)
+)
+
+// tableConstraintWords start the table constraints that may follow the
+// column definitions.
+var tableConstraintWords = map[string]bool{"CONSTRAINT": true, "PRIMARY": true, "UNIQUE": true, "CHECK": true, "FOREIGN": true}
+
+// splitTableSQL splits CREATE TABLE into normalized column definitions
+// keyed by lowercased name, table constraints and trailing options.
+func splitTableSQL(stmt string) (map[string]string, []string, string) {
+	defs := map[string]string{}
+	loc := createTablePattern.FindStringIndex(stmt)
+	if loc == nil {
+		return defs, nil, ""
+	}
+	items, end := splitSQLList(stmt[loc[1]:])
+	var constraints []string
+	for _, item := range items {
+		item = normalizeSQL(item)
+		name := leadingNamePattern.FindString(item)
+		if tableConstraintWords[strings.ToUpper(name)] {
+			constraints = append(constraints, item)
+			continue
+		}
+		defs[strings.ToLower(unquoteSQL(name))] = item
+	}
+	return defs, constraints, normalizeSQL(stmt[loc[1]+end:])
+}
+
+// renameCreateTable rewrites the table name of CREATE TABLE.
+func renameCreateTable(stmt, name string) string {
+	m := createTablePattern.FindStringSubmatchIndex(stmt)
+	if m == nil {
+		return stmt
+	}
+	return stmt[:m[2]] + QuoteIdentifier(name) + stmt[m[3]:]
+}
+
+// normalizeSQL drops comments and redundant whitespace outside quotes,
+// so statements that differ only in layout compare equal.
+func normalizeSQL(s string) string {
+	var b strings.Builder
+	space := false
+	emit := func(ch byte) {
+		if space && b.Len() > 0 && !strings.ContainsRune("(,", rune(b.String()[b.Len()-1])) && !strings.ContainsRune("),(", rune(ch)) {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteByte(ch)
+	}
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '\'' || ch == '"' || ch == '`' || ch == '[':
+			end := ch
+			if ch == '[' {
+				end = ']'
+			}
+			emit(ch)
+			for i++; i < len(s); i++ {
+				b.WriteByte(s[i])
+				if s[i] == end {
+					if end != ']' && i+1 < len(s) && s[i+1] == end {
+						i++
+						b.WriteByte(s[i])
+						continue
+					}
+					break
+				}
+			}
+		case ch == '-' && strings.HasPrefix(s[i:], "--"):
+			if n := strings.IndexByte(s[i:], '\n'); n >= 0 {
+				i += n
+			} else {
+				i = len(s)
+			}
+			space = true
+		case ch == '/' && strings.HasPrefix(s[i:], "/*"):
+			if n := strings.Index(s[i+2:], "*/"); n >= 0 {
+				i += n + 3
+			} else {
+				i = len(s)
+			}
+			space = true
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			space = true
+		default:
+			emit(ch)
+		}
+	}
+	return strings.TrimSuffix(b.String(), ";")
+}
+
+func sameDiffColumn(a, b DiffColumn) bool {
+	return strings.EqualFold(a.Type, b.Type) && a.NotNull == b.NotNull && a.Default == b.Default && a.PrimaryKey == b.PrimaryKey && a.Hidden == b.Hidden
+}
+
+// columnNames lists the table's column names, lowercased, in order.
+func (t *schemaTable) columnNames() []string {
+	names := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		names[i] = strings.ToLower(col.Name)
+	}
+	return names
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// compareTables diffs a table present in both schemas, returning nil if
+// it is unchanged.
+func compareTables(a, b *schemaTable) *TableDiff {
+	td := &TableDiff{Name: b.Name, Change: "changed", From: a.SQL, To: b.SQL}
+	if a.Virtual || b.Virtual {
+		if a.Virtual == b.Virtual && normalizeSQL(a.SQL) == normalizeSQL(b.SQL) {
+			return nil
+		}
+		td.Strategy = "recreate"
+		return td
+	}
+
+	for _, col := range a.Columns {
+		to := b.column(col.Name)
+		switch {
+		case to == nil:
+			from := col
+			td.Columns = append(td.Columns, ColumnDiff{Name: col.Name, Change: "removed", From: &from})
+		case !sameDiffColumn(col, *to):
+			from := col
+			td.Columns = append(td.Columns, ColumnDiff{Name: to.Name, Change: "changed", From: &from, To: to})
+		}
+	}
+	added := 0
+	for _, col := range b.Columns {
+		if a.column(col.Name) == nil {
+			to := col
+			td.Columns = append(td.Columns, ColumnDiff{Name: col.Name, Change: "added", To: &to})
+			added++
+		}
+	}
+
+	sameTable := a.WithoutRowid == b.WithoutRowid && sameStrings(a.Constraints, b.Constraints) && a.Options == b.Options
+	if len(td.Columns) == 0 && sameTable && sameStrings(a.columnNames(), b.columnNames()) {
+		same := true
+		for name, def := range a.Defs {
+			same = same && b.Defs[name] == def
+		}
+		if same {
+			return nil
+		}
+	}
+
+	// New columns at the end of an otherwise unchanged table can be added
+	// in place
+	td.Strategy = "rebuild"
+	if sameTable && added > 0 && added == len(td.Columns) && sameStrings(a.columnNames(), b.columnNames()[:len(a.Columns)]) {
+		addable := true
+		for _, name := range a.columnNames() {
+			addable = addable && a.Defs[name] == b.Defs[name]
+		}
+		for _, name := range b.columnNames()[len(a.Columns):] {
+			addable = addable && addableDefinition(b.Defs[name])
+		}
+		if addable {
+			td.Strategy = "add-column"
+		}
+	}
+	return td
+}
+
+// addableDefinition reports whether ALTER TABLE ADD COLUMN accepts a
+// column definition: no PRIMARY KEY, UNIQUE or STORED, a constant
+// default, a non-NULL default for NOT NULL and a NULL default with
+// REFERENCES.
+func addableDefinition(def string) bool {
+	if def == "" {
+		return false
+	}
+	tokens := tokenizeSQL(def)
+	var notNull, references bool
+	dflt := ""
+	for i, t := range tokens {
+		if t.kind != tokenWord {
+			continue
+		}
+		switch word := strings.ToUpper(t.text); word {
+		case "PRIMARY", "UNIQUE", "STORED":
+			return false
+		case "NOT":
+			notNull = notNull || (i+1 < len(tokens) && strings.EqualFold(tokens[i+1].text, "NULL"))
+		case "REFERENCES":
+			references = true
+		case "DEFAULT":
+			if i+1 >= len(tokens) {
+				return false
+			}
+			next := tokens[i+1]
+			if next.text == "(" || (next.kind == tokenWord && strings.HasPrefix(strings.ToUpper(next.text), "CURRENT_")) {
+				return false
+			}
+			dflt = strings.ToUpper(next.text)
+			if next.kind == tokenString {
+				dflt = "'"
+			}
+		}
+	}
+	if notNull && (dflt == "" || dflt == "NULL") {
+		return false
+	}
+	return !references || dflt == "" || dflt == "NULL"
+}
+
+// compareObjects diffs the indexes, triggers or views of two schemas.
+func compareObjects(typ string, from, to *dbSchema) []ObjectDiff {
+	diffs := []ObjectDiff{}
+	for _, o := range from.Objects {
+		if o.Type != typ {
+			continue
+		}
+		switch other := to.object(typ, o.Name); {
+		case other == nil:
+			diffs = append(diffs, ObjectDiff{Name: o.Name, Table: o.Table, Change: "removed", From: o.SQL})
+		case normalizeSQL(o.SQL) != normalizeSQL(other.SQL):
+			diffs = append(diffs, ObjectDiff{Name: other.Name, Table: other.Table, Change: "changed", From: o.SQL, To: other.SQL})
+		}
+	}
+	for _, o := range to.Objects {
+		if o.Type == typ && from.object(typ, o.Name) == nil {
+			diffs = append(diffs, ObjectDiff{Name: o.Name, Table: o.Table, Change: "added", To: o.SQL})
+		}
+	}
+	return diffs
+}
+
+// nameSet is a set of lowercased object names.
+type nameSet map[string]bool
+
+func (ns nameSet) has(name string) bool { return ns[strings.ToLower(name)] }
+func (ns nameSet) add(name string)      { ns[strings.ToLower(name)] = true }
+
+// mentions reports whether stmt names any object in ns as a whole word.
+func (ns nameSet) mentions(stmt string) bool {
+	if len(ns) == 0 {
+		return false
+	}
+	names := make([]string, 0, len(ns))
+	for name := range ns {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	sort.Strings(names)
+	return regexp.MustCompile(`(?i)(^|[^\w$])(` + strings.Join(names, "|") + `)([^\w$]|$)`).MatchString(stmt)
+}
+
+func diffNames(diffs []ObjectDiff, change ...string) nameSet {
+	ns := nameSet{}
+	for _, d := range diffs {
+		for _, c := range change {
+			if d.Change == c {
+				ns.add(d.Name)
+			}
+		}
+	}
+	return ns
+}
+
+// newSchemaDiff compares two schemas and writes the migration script.
+// Tables are changed with ALTER TABLE ADD COLUMN where SQLite allows it
+// and otherwise rebuilt: created under a new name, filled from the old
+// table, which is dropped, and renamed. Views and triggers that refer to
+// a rebuilt or dropped table are dropped first and created again from B
+// afterwards, since they would break the rename.
+func newSchemaDiff(from, to *dbSchema) *SchemaDiff {
+	d := &SchemaDiff{
+		Tables:   []TableDiff{},
+		Indexes:  compareObjects("index", from, to),
+		Triggers: compareObjects("trigger", from, to),
+		Views:    compareObjects("view", from, to),
+		Warnings: []string{},
+	}
+
+	// Tables dropped from A, whole or for a rebuild, and tables created
+	// in B, whole or by a rebuild
+	dropped, created, rebuilt := nameSet{}, nameSet{}, nameSet{}
+	var addColumns, rebuilds []*TableDiff
+	for _, o := range from.Objects {
+		if o.Type != "table" {
+			continue
+		}
+		a, b := from.Tables[strings.ToLower(o.Name)], to.Tables[strings.ToLower(o.Name)]
+		if b == nil {
+			d.Tables = append(d.Tables, TableDiff{Name: a.Name, Change: "removed", Strategy: "drop", From: a.SQL})
+			d.Warnings = append(d.Warnings, fmt.Sprintf("table %s is dropped with its rows", a.Name))
+			dropped.add(a.Name)
+			continue
+		}
+		if td := compareTables(a, b); td != nil {
+			d.Tables = append(d.Tables, *td)
+		}
+	}
+	for _, o := range to.Objects {
+		if o.Type == "table" && from.Tables[strings.ToLower(o.Name)] == nil {
+			d.Tables = append(d.Tables, TableDiff{Name: o.Name, Change: "added", Strategy: "create", To: o.SQL})
+			created.add(o.Name)
+		}
+	}
+	for i := range d.Tables {
+		td := &d.Tables[i]
+		switch td.Strategy {
+		case "recreate":
+			dropped.add(td.Name)
+			created.add(td.Name)
+			d.Warnings = append(d.Warnings, fmt.Sprintf("virtual table %s is recreated without its rows", td.Name))
+		case "add-column":
+			addColumns = append(addColumns, td)
+		case "rebuild":
+			rebuilt.add(td.Name)
+			rebuilds = append(rebuilds, td)
+			for _, col := range td.Columns {
+				switch {
+				case col.Change == "removed":
+					d.Warnings = append(d.Warnings, fmt.Sprintf("column %s.%s is dropped with its data", td.Name, col.Name))
+				case col.Change == "added" && col.To.NotNull && col.To.Default == "" && col.To.Hidden < 2:
+					d.Warnings = append(d.Warnings, fmt.Sprintf("column %s.%s is NOT NULL without a default; copying rows fails unless the table is empty", td.Name, col.Name))
+				case col.Change == "changed" && col.To.NotNull && !col.From.NotNull && col.To.Hidden < 2:
+					d.Warnings = append(d.Warnings, fmt.Sprintf("column %s.%s becomes NOT NULL; copying rows fails if it holds NULLs", td.Name, col.Name))
+				}
+			}
+		}
+	}
+
+	// Views and triggers to drop: changed or removed ones, and those that
+	// refer to a table or view that goes away for a while
+	gone := nameSet{}
+	for name := range dropped {
+		gone.add(name)
+	}
+	for name := range rebuilt {
+		gone.add(name)
+	}
+	changedViews := diffNames(d.Views, "removed", "changed")
+	changedTriggers := diffNames(d.Triggers, "removed", "changed")
+	dropViews, dropTriggers := nameSet{}, nameSet{}
+	for more := true; more; {
+		more = false
+		for _, o := range from.Objects {
+			if o.Type == "view" && !dropViews.has(o.Name) && (changedViews.has(o.Name) || gone.mentions(o.SQL)) {
+				dropViews.add(o.Name)
+				gone.add(o.Name)
+				more = true
+			}
+		}
+	}
+	for _, o := range from.Objects {
+		if o.Type == "trigger" && (changedTriggers.has(o.Name) || gone.has(o.Table) || gone.mentions(o.SQL)) {
+			dropTriggers.add(o.Name)
+		}
+	}
+
+	var stmts []string
+	for _, o := range from.Objects {
+		if o.Type == "trigger" && dropTriggers.has(o.Name) {
+			stmts = append(stmts, "DROP TRIGGER "+QuoteIdentifier(o.Name))
+		}
+	}
+	for i := len(from.Objects) - 1; i >= 0; i-- {
+		if o := from.Objects[i]; o.Type == "view" && dropViews.has(o.Name) {
+			stmts = append(stmts, "DROP VIEW "+QuoteIdentifier(o.Name))
+		}
+	}
+	// Indexes of dropped and rebuilt tables go with the table
+	for _, idx := range d.Indexes {
+		if idx.Change != "added" && !dropped.has(idx.Table) && !rebuilt.has(idx.Table) {
+			stmts = append(stmts, "DROP INDEX "+QuoteIdentifier(idx.Name))
+		}
+	}
+	for _, o := range from.Objects {
+		if o.Type == "table" && dropped.has(o.Name) {
+			stmts = append(stmts, "DROP TABLE "+QuoteIdentifier(o.Name))
+		}
+	}
+	for _, o := range to.Objects {
+		if o.Type == "table" && created.has(o.Name) {
+			stmts = append(stmts, o.SQL)
+		}
+	}
+	for _, td := range addColumns {
+		b := to.Tables[strings.ToLower(td.Name)]
+		for _, col := range td.Columns {
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", QuoteIdentifier(b.Name), b.Defs[strings.ToLower(col.Name)]))
+		}
+	}
+	var checked []string
+	for _, td := range rebuilds {
+		a, b := from.Tables[strings.ToLower(td.Name)], to.Tables[strings.ToLower(td.Name)]
+		stmts = append(stmts, rebuildTableStatements(a, b, from, to)...)
+		checked = append(checked, b.Name)
+	}
+
+	// Create what B has that the script added or dropped along the way,
+	// in B's order so views and triggers follow what they refer to
+	addedObjects := diffNames(append(append(append([]ObjectDiff{}, d.Indexes...), d.Views...), d.Triggers...), "added", "changed")
+	for _, o := range to.Objects {
+		var create bool
+		switch o.Type {
+		case "index":
+			create = addedObjects.has(o.Name) || rebuilt.has(o.Table) || (created.has(o.Table) && dropped.has(o.Table))
+		case "view":
+			create = addedObjects.has(o.Name) || dropViews.has(o.Name)
+		case "trigger":
+			create = addedObjects.has(o.Name) || dropTriggers.has(o.Name) || rebuilt.has(o.Table) || created.has(o.Table)
+		}
+		if create {
+			stmts = append(stmts, o.SQL)
+		}
+	}
+
+	if len(stmts) > 0 {
+		d.Script = alterScript(stmts, len(rebuilt) > 0 || len(dropped) > 0, checked)
+	}
+	return d
+}
+
+// rebuildTableStatements rebuilds table a as b, copying the columns both
+// have and the rowids where both tables keep them.
+func rebuildTableStatements(a, b *schemaTable, from, to *dbSchema) []string {
+	newName := b.Name + "_new"
+	for i := 2; from.object("table", newName) != nil || to.object("table", newName) != nil; i++ {
+		newName = fmt.Sprintf("%s_new%d", b.Name, i)
+	}
+	var into, sel []string
+	if !a.WithoutRowid && !b.WithoutRowid && !b.hasRowidAlias() {
+		into, sel = append(into, "rowid"), append(sel, "rowid")
+	}
+	for _, col := range b.Columns {
+		if src := a.column(col.Name); src != nil && col.Hidden < 2 && src.Hidden < 2 {
+			into = append(into, QuoteIdentifier(col.Name))
+			sel = append(sel, QuoteIdentifier(src.Name))
+		}
+	}
+	stmts := []string{renameCreateTable(b.SQL, newName)}
+	if len(into) > 0 {
+		stmts = append(stmts, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s",
+			QuoteIdentifier(newName), strings.Join(into, ", "), strings.Join(sel, ", "), QuoteIdentifier(a.Name)))
+	}
+	return append(stmts,
+		"DROP TABLE "+QuoteIdentifier(a.Name),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", QuoteIdentifier(newName), QuoteIdentifier(b.Name)))
+}
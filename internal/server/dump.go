@@ -0,0 +1,411 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dumpOptions selects what writeDump emits.
+type dumpOptions struct {
+	Schema bool
+	Data   bool
+	// Tables limits the dump to these tables, their indexes and triggers,
+	// and views of the same name. Empty means everything.
+	Tables []string
+}
+
+type dumpObject struct {
+	Type  string
+	Name  string
+	Table string
+	SQL   string
+}
+
+func (s *Server) handleExportDatabase(c *gin.Context) {
+	format := c.DefaultQuery("format", "sql")
+	tables, ok := parseTableList(c, c.Query("tables"))
+	if !ok {
+		return
+	}
+	// A zip bundle holds a file per table in any export format
+	if bundle := c.Query("bundle"); bundle != "" {
+		if bundle != "zip" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bundle must be zip"})
+			return
+		}
+		if c.Query("compress") != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a zip bundle is already compressed"})
+			return
+		}
+		e, ok := prepareExporter(c, format, queryExportOptions(c))
+		if !ok {
+			return
+		}
+		if err := s.exportBundle(c, format, e, tables); err != nil {
+			exportFailed(c, err)
+		}
+		return
+	}
+	if format != "sql" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported format"})
+		return
+	}
+	if format == "xlsx" {
+		if err := s.exportWorkbook(c, tables); err != nil {
+			exportFailed(c, err)
+		}
+		return
+	}
+
+	opts := dumpOptions{Schema: true, Data: true, Tables: tables}
+	switch c.DefaultQuery("mode", "full") {
+	case "full":
+	case "schema":
+		opts.Data = false
+	case "data":
+		opts.Schema = false
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be full, schema or data"})
+		return
+	}
+	comp, ok := parseCompression(c, c.Query("compress"))
+	if !ok {
+		return
+	}
+	setDownloadHeaders(c, s.backupBaseName()+".sql", "application/sql", comp)
+	w, err := newExportWriter(c, comp)
+	if err == nil {
+		if err = s.writeDump(c.Request.Context(), w, opts); err == nil {
+			err = w.close()
+		}
+	}
+	if err != nil {
+		exportFailed(c, err)
+	}
+}
+
+// parseTableList splits a comma separated list of table names, responding
+// with 400 if any name is invalid.
+func parseTableList(c *gin.Context, list string) ([]string, bool) {
+	var tables []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !IsSafeIdentifier(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid table name: %s", name)})
+			return nil, false
+		}
+		tables = append(tables, name)
+	}
+	return tables, true
+}
+
+// writeDump writes an SQL script in the style of the sqlite3 shell's .dump
+// command. It reads inside one transaction so the script is consistent.
+func (s *Server) writeDump(ctx context.Context, w io.Writer, opts dumpOptions) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	objects, err := loadDumpObjects(tx)
+	if err != nil {
+		return err
+	}
+	selected := map[string]bool{}
+	for _, t := range opts.Tables {
+		selected[strings.ToLower(t)] = true
+	}
+	if len(selected) > 0 {
+		// A virtual table is dumped without data; its rows live in the
+		// shadow tables, which go along with it
+		if err := selectShadowTables(tx, selected); err != nil {
+			return err
+		}
+	}
+	include := func(name string) bool {
+		return len(selected) == 0 || selected[strings.ToLower(name)]
+	}
+
+	var tables, others []dumpObject
+	for _, obj := range objects {
+		switch {
+		case obj.Type == "table" && strings.HasPrefix(obj.Name, "sqlite_"):
+			// sqlite_sequence is written after the data; statistics are skipped
+		case obj.Type == "table" && include(obj.Name):
+			tables = append(tables, obj)
+		case obj.Type == "view" && include(obj.Name):
+			others = append(others, obj)
+		case (obj.Type == "index" || obj.Type == "trigger") && include(obj.Table):
+			others = append(others, obj)
+		}
+	}
+	tables, err = orderByDependencies(tx, tables)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n")
+	writableSchema := false
+	for _, table := range tables {
+		isVirtual := strings.HasPrefix(strings.ToUpper(table.SQL), "CREATE VIRTUAL TABLE")
+		if opts.Schema {
+			if isVirtual {
+				// Creating the virtual table would also create its shadow
+				// tables, which are dumped on their own
+				if !writableSchema {
+					bw.WriteString("PRAGMA writable_schema=ON;\n")
+					writableSchema = true
+				}
+				fmt.Fprintf(bw, "INSERT INTO sqlite_schema(type,name,tbl_name,rootpage,sql) VALUES('table',%s,%s,0,%s);\n",
+					sqlString(table.Name), sqlString(table.Name), sqlString(table.SQL))
+			} else {
+				fmt.Fprintf(bw, "%s;\n", table.SQL)
+			}
+		}
+		if opts.Data && !isVirtual {
+			if err := writeTableInserts(ctx, tx, bw, table.Name); err != nil {
+				return err
+			}
+		}
+	}
+	if writableSchema {
+		bw.WriteString("PRAGMA writable_schema=OFF;\n")
+	}
+	if opts.Data {
+		if err := writeSequences(tx, bw, include); err != nil {
+			return err
+		}
+	}
+	if opts.Schema {
+		for _, obj := range others {
+			fmt.Fprintf(bw, "%s;\n", obj.SQL)
+		}
+	}
+	bw.WriteString("COMMIT;\n")
+	return bw.Flush()
+}
+
+func loadDumpObjects(tx *sql.Tx) ([]dumpObject, error) {
+	rows, err := tx.Query(`SELECT type, name, tbl_name, sql FROM sqlite_schema WHERE sql IS NOT NULL ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []dumpObject
+	for rows.Next() {
+		var obj dumpObject
+		if err := rows.Scan(&obj.Type, &obj.Name, &obj.Table, &obj.SQL); err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// selectShadowTables adds the shadow tables of every selected virtual
+// table to selected.
+func selectShadowTables(tx *sql.Tx, selected map[string]bool) error {
+	rows, err := tx.Query(`SELECT name, type FROM pragma_table_list WHERE schema = 'main' AND type IN ('virtual', 'shadow')`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	virtual := map[string]bool{}
+	var shadows []string
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return err
+		}
+		if typ == "virtual" {
+			virtual[name] = true
+		} else {
+			shadows = append(shadows, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, shadow := range shadows {
+		if owner := shadowOwnerName(shadow, virtual); owner != "" && selected[strings.ToLower(owner)] {
+			selected[strings.ToLower(shadow)] = true
+		}
+	}
+	return nil
+}
+
+// orderByDependencies sorts tables so that referenced tables come before
+// the tables referencing them, keeping schema order otherwise. Cycles are
+// broken at the point they are found.
+func orderByDependencies(tx *sql.Tx, tables []dumpObject) ([]dumpObject, error) {
+	byName := map[string]dumpObject{}
+	for _, t := range tables {
+		byName[strings.ToLower(t.Name)] = t
+	}
+	parents := map[string][]string{}
+	for _, t := range tables {
+		names, err := parentTables(tx, t.Name)
+		if err != nil {
+			return nil, err
+		}
+		parents[strings.ToLower(t.Name)] = names
+	}
+
+	ordered := make([]dumpObject, 0, len(tables))
+	state := map[string]int{} // 1 visiting, 2 done
+	var visit func(name string)
+	visit = func(name string) {
+		if state[name] != 0 {
+			return
+		}
+		state[name] = 1
+		for _, parent := range parents[name] {
+			if _, ok := byName[parent]; ok {
+				visit(parent)
+			}
+		}
+		state[name] = 2
+		ordered = append(ordered, byName[name])
+	}
+	for _, t := range tables {
+		visit(strings.ToLower(t.Name))
+	}
+	return ordered, nil
+}
+
+// parentTables lists the tables that table's foreign keys reference, in
+// lower case.
+func parentTables(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query(`SELECT DISTINCT lower("table") FROM pragma_foreign_key_list(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// writeTableInserts emits one INSERT per row, using quote() so BLOBs become
+// X'..' literals and REAL values keep full precision. Generated columns are
+// left out since they cannot be inserted.
+func writeTableInserts(ctx context.Context, tx *sql.Tx, w *bufio.Writer, table string) error {
+	cols, hasHidden, err := insertableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+	if len(cols) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(cols))
+	exprs := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = QuoteIdentifier(col)
+		exprs[i] = fmt.Sprintf("quote(%s)", QuoteIdentifier(col))
+	}
+	prefix := fmt.Sprintf("INSERT INTO %s VALUES(", QuoteIdentifier(table))
+	if hasHidden {
+		prefix = fmt.Sprintf("INSERT INTO %s(%s) VALUES(", QuoteIdentifier(table), strings.Join(quoted, ","))
+	}
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(exprs, ", "), QuoteIdentifier(table)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]string, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		w.WriteString(prefix)
+		w.WriteString(strings.Join(values, ","))
+		w.WriteString(");\n")
+	}
+	return rows.Err()
+}
+
+// insertableColumns lists the non-generated columns of table and reports
+// whether any were left out.
+func insertableColumns(tx *sql.Tx, table string) ([]string, bool, error) {
+	rows, err := tx.Query(`SELECT name, hidden FROM pragma_table_xinfo(?) ORDER BY cid`, table)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var cols []string
+	hasHidden := false
+	for rows.Next() {
+		var name string
+		var hidden int
+		if err := rows.Scan(&name, &hidden); err != nil {
+			return nil, false, err
+		}
+		if hidden != 0 {
+			hasHidden = true
+			continue
+		}
+		cols = append(cols, name)
+	}
+	return cols, hasHidden, rows.Err()
+}
+
+// writeSequences restores AUTOINCREMENT counters of the dumped tables.
+func writeSequences(tx *sql.Tx, w *bufio.Writer, include func(string) bool) error {
+	var exists int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM sqlite_schema WHERE type = 'table' AND name = 'sqlite_sequence'`).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return nil
+	}
+	rows, err := tx.Query(`SELECT name, quote(name), quote(seq) FROM sqlite_sequence`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, quotedName, seq string
+		if err := rows.Scan(&name, &quotedName, &seq); err != nil {
+			return err
+		}
+		if !include(name) {
+			continue
+		}
+		fmt.Fprintf(w, "DELETE FROM sqlite_sequence WHERE name = %s;\n", quotedName)
+		fmt.Fprintf(w, "INSERT INTO sqlite_sequence(name,seq) VALUES(%s,%s);\n", quotedName, seq)
+	}
+	return rows.Err()
+}
+
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
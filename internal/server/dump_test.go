@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteDump(t *testing.T) {
+	const schema = `
+CREATE TABLE child(id INTEGER PRIMARY KEY, parent_id REFERENCES parent(id));
+CREATE TABLE parent(id INTEGER PRIMARY KEY, name TEXT);
+CREATE VIRTUAL TABLE docs USING fts5(body);
+CREATE TABLE other(x);
+INSERT INTO parent VALUES (1, 'p');
+INSERT INTO child VALUES (1, 1);
+INSERT INTO docs(body) VALUES ('hello world');
+INSERT INTO other VALUES (1);`
+	tests := []struct {
+		name   string
+		tables []string
+		// want maps a query against the reloaded dump to its result
+		want map[string]string
+		// absent lists tables that must not be in the dump
+		absent []string
+	}{
+		{
+			name: "everything",
+			want: map[string]string{
+				"SELECT name FROM parent JOIN child ON child.parent_id = parent.id": "p",
+				"SELECT body FROM docs WHERE docs MATCH 'hello'":                    "hello world",
+			},
+		},
+		{
+			name:   "virtual table brings its shadow tables",
+			tables: []string{"docs"},
+			want:   map[string]string{"SELECT body FROM docs WHERE docs MATCH 'world'": "hello world"},
+			absent: []string{"parent", "child", "other"},
+		},
+		{
+			name:   "referenced table first",
+			tables: []string{"child", "parent"},
+			want:   map[string]string{"SELECT name FROM parent JOIN child ON child.parent_id = parent.id": "p"},
+			absent: []string{"docs", "other"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, schema, Options{})
+			var buf bytes.Buffer
+			err := s.writeDump(context.Background(), &buf, dumpOptions{Schema: true, Data: true, Tables: tt.tables})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dump := buf.String()
+			if p, c := strings.Index(dump, "CREATE TABLE parent"), strings.Index(dump, "CREATE TABLE child"); p >= 0 && c >= 0 && c < p {
+				t.Error("child created before parent")
+			}
+
+			path := filepath.Join(t.TempDir(), "reload.db")
+			db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(dump); err != nil {
+				t.Fatalf("reload: %v\n%s", err, dump)
+			}
+			// Virtual tables written through writable_schema appear once
+			// the schema is read again
+			db.Close()
+			if db, err = sql.Open("sqlite", path); err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			for query, want := range tt.want {
+				var got string
+				if err := db.QueryRow(query).Scan(&got); err != nil {
+					t.Errorf("%s: %v", query, err)
+				} else if got != want {
+					t.Errorf("%s = %q, want %q", query, got, want)
+				}
+			}
+			for _, name := range tt.absent {
+				var n int
+				if err := db.QueryRow(`SELECT COUNT(1) FROM sqlite_schema WHERE name = ?`, name).Scan(&n); err != nil {
+					t.Fatal(err)
+				}
+				if n != 0 {
+					t.Errorf("table %s dumped", name)
+				}
+			}
+		})
+	}
+}
@@ -1,81 +1,623 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/csv"
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func (s *Server) exportJSON(c *gin.Context, table string) error {
-	_, rows, err := s.fetchAllRows(table)
-	if err != nil {
-		return err
+// exportFlushRows is how many rows are buffered before an export pushes
+// its output to the client.
+const exportFlushRows = 1000
+
+// rowIterator is what exporters read: the column names, then one row per
+// call to Next until io.EOF.
+type rowIterator interface {
+	Columns() []string
+	Next() ([]interface{}, error)
+}
+
+// exportSource describes the data being exported.
+type exportSource struct {
+	// Name is the table name, or the name chosen for a query export.
+	Name string
+	// Schema is the CREATE TABLE statement of an exported table; empty
+	// for query results.
+	Schema string
+}
+
+// exporter writes a result set in one file format. Implementations
+// register themselves with registerExporter and are then available to
+// every export endpoint.
+type exporter interface {
+	ContentType() string
+	Extension() string
+	Export(w io.Writer, src exportSource, rows rowIterator) error
+}
+
+var exporters = map[string]exporter{}
+
+// exportOptions are the format specific settings of an export, taken from
+// the query string or the request body.
+type exportOptions map[string]string
+
+// configurableExporter is implemented by exporters that accept options.
+type configurableExporter interface {
+	exporter
+	// Configure returns a copy of the exporter set up with opts.
+	Configure(opts exportOptions) (exporter, error)
+}
+
+func registerExporter(format string, e exporter) {
+	if _, dup := exporters[format]; dup {
+		panic("export format registered twice: " + format)
+	}
+	exporters[format] = e
+}
+
+func exportFormats() []string {
+	names := make([]string, 0, len(exporters))
+	for name := range exporters {
+		names = append(names, name)
 	}
-	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, table))
-	c.Header("Content-Type", "application/json")
-	return json.NewEncoder(c.Writer).Encode(rows)
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	registerExporter("csv", csvExporter{dialect: defaultCSVDialect})
+	registerExporter("json", jsonExporter{})
+	registerExporter("sql", sqlExporter{})
+	registerExporter("xlsx", xlsxExporter{})
 }
 
-func (s *Server) exportCSV(c *gin.Context, table string) error {
-	columns, rows, err := s.fetchAllRows(table)
+// rowStream reads a result set one row at a time so an export only ever
+// holds the current row in memory.
+type rowStream struct {
+	rows    *sql.Rows
+	columns []string
+	values  []interface{}
+	ptrs    []interface{}
+}
+
+// contextQuerier is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type contextQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// openRowStream runs query under ctx, so the scan stops as soon as the
+// client goes away.
+func openRowStream(ctx context.Context, q contextQuerier, query string, args ...interface{}) (*rowStream, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, table))
-	c.Header("Content-Type", "text/csv")
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rs := &rowStream{
+		rows:    rows,
+		columns: columns,
+		values:  make([]interface{}, len(columns)),
+		ptrs:    make([]interface{}, len(columns)),
+	}
+	for i := range rs.values {
+		rs.ptrs[i] = &rs.values[i]
+	}
+	return rs, nil
+}
+
+func (rs *rowStream) Columns() []string {
+	return rs.columns
+}
+
+// Next returns the next row, or io.EOF after the last one. The slice is
+// reused between calls.
+func (rs *rowStream) Next() ([]interface{}, error) {
+	if !rs.rows.Next() {
+		if err := rs.rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	if err := rs.rows.Scan(rs.ptrs...); err != nil {
+		return nil, err
+	}
+	for i, v := range rs.values {
+		rs.values[i] = normalizeValue(v)
+	}
+	return rs.values, nil
+}
+
+func (rs *rowStream) Close() error {
+	return rs.rows.Close()
+}
+
+// exportWriter buffers a download, optionally compressing it, and hands it
+// to the client every exportFlushRows rows.
+type exportWriter struct {
+	*bufio.Writer
+	c    *gin.Context
+	comp compressWriter
+	rows int
+}
+
+func newExportWriter(c *gin.Context, comp *compression) (*exportWriter, error) {
+	w := &exportWriter{c: c}
+	var out io.Writer = c.Writer
+	if comp != nil {
+		cw, err := comp.newWriter(c.Writer)
+		if err != nil {
+			return nil, err
+		}
+		w.comp, out = cw, cw
+	}
+	w.Writer = bufio.NewWriterSize(out, 64*1024)
+	return w, nil
+}
+
+// endRow counts a written row and flushes periodically. It fails once the
+// client has disconnected.
+func (w *exportWriter) endRow() error {
+	w.rows++
+	if w.rows%exportFlushRows != 0 {
+		return nil
+	}
+	return w.flush()
+}
 
-	writer := csv.NewWriter(c.Writer)
-	if err := writer.Write(columns); err != nil {
+func (w *exportWriter) flush() error {
+	if err := w.Writer.Flush(); err != nil {
 		return err
 	}
-	for _, row := range rows {
-		record := make([]string, len(columns))
-		for i, col := range columns {
-			record[i] = csvValue(row[col])
+	if w.comp != nil {
+		if err := w.comp.Flush(); err != nil {
+			return err
 		}
-		if err := writer.Write(record); err != nil {
+	}
+	w.c.Writer.Flush()
+	return w.c.Request.Context().Err()
+}
+
+// close finishes the download, writing the compression trailer if any.
+func (w *exportWriter) close() error {
+	if err := w.Writer.Flush(); err != nil {
+		return err
+	}
+	if w.comp != nil {
+		if err := w.comp.Close(); err != nil {
 			return err
 		}
 	}
-	writer.Flush()
-	return writer.Error()
+	w.c.Writer.Flush()
+	return nil
+}
+
+// exportFailed reports err in place of the download, or, once part of the
+// file has been sent, only logs it since the client already sees a
+// truncated response.
+func exportFailed(c *gin.Context, err error) {
+	if c.Writer.Written() {
+		c.Error(err)
+		return
+	}
+	c.Writer.Header().Del("Content-Disposition")
+	c.Writer.Header().Del("Content-Type")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// flushingRows hands rows to an exporter and flushes the download every
+// exportFlushRows rows, so exporters need not know about the response.
+type flushingRows struct {
+	rowIterator
+	w       *exportWriter
+	started bool
+}
+
+func (fr *flushingRows) Next() ([]interface{}, error) {
+	if fr.started {
+		if err := fr.w.endRow(); err != nil {
+			return nil, err
+		}
+	}
+	fr.started = true
+	return fr.rowIterator.Next()
+}
+
+// prepareExporter looks up format and applies opts, responding with 400 if
+// either is invalid.
+func prepareExporter(c *gin.Context, format string, opts exportOptions) (exporter, bool) {
+	e, ok := exporters[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported format", "formats": exportFormats()})
+		return nil, false
+	}
+	if ce, ok := e.(configurableExporter); ok {
+		configured, err := ce.Configure(opts)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		e = configured
+	}
+	return e, true
+}
+
+// queryExportOptions takes export options from the query string.
+func queryExportOptions(c *gin.Context) exportOptions {
+	opts := exportOptions{}
+	for key, values := range c.Request.URL.Query() {
+		if len(values) > 0 {
+			opts[key] = values[0]
+		}
+	}
+	return opts
 }
 
-func (s *Server) exportSQL(c *gin.Context, table string) error {
-	schema, err := s.getTableSchema(table)
+// writeExport streams rows as a download written by e and compressed
+// with comp, if not nil.
+func writeExport(c *gin.Context, e exporter, comp *compression, src exportSource, rows rowIterator) error {
+	setDownloadHeaders(c, fmt.Sprintf("%s.%s", src.Name, e.Extension()), e.ContentType(), comp)
+
+	w, err := newExportWriter(c, comp)
 	if err != nil {
 		return err
 	}
-	columns, rows, err := s.fetchAllRows(table)
-	if err != nil {
+	if err := e.Export(w, src, &flushingRows{rowIterator: rows, w: w}); err != nil {
 		return err
 	}
+	return w.close()
+}
+
+func (s *Server) handleListExportFormats(c *gin.Context) {
+	formats := []gin.H{}
+	for _, name := range exportFormats() {
+		e := exporters[name]
+		formats = append(formats, gin.H{"format": name, "extension": e.Extension(), "contentType": e.ContentType()})
+	}
+	c.JSON(http.StatusOK, gin.H{"formats": formats})
+}
+
+// handleExportQuery streams the result of a read-only query. Params are
+// bound positionally when given as an array and by name when given as an
+// object.
+func (s *Server) handleExportQuery(c *gin.Context) {
+	var req struct {
+		Query    string          `json:"query"`
+		Params   json.RawMessage `json:"params"`
+		Format   string          `json:"format"`
+		Name     string          `json:"name"`
+		Options  exportOptions   `json:"options"`
+		Compress string          `json:"compress"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+		return
+	}
+	if err := checkReadQuery(req.Query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Format == "" {
+		req.Format = "csv"
+	}
+	e, ok := prepareExporter(c, req.Format, req.Options)
+	if !ok {
+		return
+	}
+	comp, ok := parseCompression(c, req.Compress)
+	if !ok {
+		return
+	}
+	if req.Name == "" {
+		req.Name = "query"
+	}
+	if !IsSafeIdentifier(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid export name"})
+		return
+	}
+	args, err := queryParams(req.Params)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer conn.Close()
+	// query_only also rejects writes the statement check cannot see, such
+	// as those done by functions of extensions
+	if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "PRAGMA query_only = OFF"); err != nil {
+			// Discard the connection rather than pool a read-only one
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+
+	rows, err := openRowStream(ctx, conn, req.Query, args...)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	if err := writeExport(c, e, comp, exportSource{Name: req.Name}, rows); err != nil {
+		exportFailed(c, err)
+	}
+}
+
+// checkReadQuery accepts a single SELECT, WITH or VALUES statement, the
+// reads the SQL console recognizes, and rejects a WITH whose main
+// statement writes.
+func checkReadQuery(query string) error {
+	tokens := tokenizeSQL(query)
+	for len(tokens) > 0 && tokens[len(tokens)-1].text == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 {
+		return errors.New("query cannot be empty")
+	}
+	switch strings.ToUpper(tokens[0].text) {
+	case "SELECT", "WITH", "VALUES":
+	default:
+		return errors.New("only SELECT queries can be exported")
+	}
+	depth := 0
+	for i, t := range tokens {
+		// replace(...) is a function, not a statement
+		if i+1 < len(tokens) && tokens[i+1].text == "(" {
+			continue
+		}
+		switch {
+		case t.kind == tokenSymbol && t.text == ";":
+			return errors.New("only a single statement can be exported")
+		case t.kind == tokenSymbol && t.text == "(":
+			depth++
+		case t.kind == tokenSymbol && t.text == ")":
+			depth--
+		case t.kind == tokenWord && depth == 0:
+			switch strings.ToUpper(t.text) {
+			case "INSERT", "UPDATE", "DELETE", "REPLACE":
+				return errors.New("only SELECT queries can be exported")
+			}
+		}
+	}
+	return nil
+}
 
-	var buf bytes.Buffer
-	buf.WriteString(schema + ";\n")
-	buf.WriteString(fmt.Sprintf("DELETE FROM %s;\n", QuoteIdentifier(table)))
+// queryParams decodes a JSON array into positional arguments or a JSON
+// object into named ones.
+func queryParams(raw json.RawMessage) ([]interface{}, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	var args []interface{}
+	switch params := decoded.(type) {
+	case []interface{}:
+		for _, v := range params {
+			args = append(args, queryParam(v))
+		}
+	case map[string]interface{}:
+		for name, v := range params {
+			args = append(args, sql.Named(strings.TrimLeft(name, ":@$"), queryParam(v)))
+		}
+	default:
+		return nil, errors.New("params must be an array or an object")
+	}
+	return args, nil
+}
 
+func queryParam(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+type jsonExporter struct{}
+
+func (jsonExporter) ContentType() string { return "application/json" }
+func (jsonExporter) Extension() string   { return "json" }
+
+func (jsonExporter) Export(w io.Writer, src exportSource, rows rowIterator) error {
+	enc := newJSONRowEncoder(rows.Columns())
+	io.WriteString(w, "[")
+	for n := 0; ; n++ {
+		values, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			io.WriteString(w, ",")
+		}
+		if err := enc.encode(w, values); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]\n")
+	return err
+}
+
+// jsonRowEncoder writes rows as JSON objects with keys in column order.
+type jsonRowEncoder struct {
+	keys [][]byte
+	buf  []byte
+}
+
+func newJSONRowEncoder(columns []string) *jsonRowEncoder {
+	enc := &jsonRowEncoder{keys: make([][]byte, len(columns))}
+	for i, col := range columns {
+		key, _ := json.Marshal(col)
+		enc.keys[i] = append(key, ':')
+	}
+	return enc
+}
+
+func (enc *jsonRowEncoder) encode(w io.Writer, values []interface{}) error {
+	b := append(enc.buf[:0], '{')
+	for i, v := range values {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		b = append(b, enc.keys[i]...)
+		b = append(b, data...)
+	}
+	b = append(b, '}')
+	enc.buf = b
+	_, err := w.Write(b)
+	return err
+}
+
+// sqlExporter writes INSERT statements. Table exports start with the
+// table's CREATE statement and clear it before inserting; query exports
+// hold only INSERTs into a table named after the export.
+type sqlExporter struct{}
+
+func (sqlExporter) ContentType() string { return "application/sql" }
+func (sqlExporter) Extension() string   { return "sql" }
+
+func (sqlExporter) Export(w io.Writer, src exportSource, rows rowIterator) error {
+	bw := bufio.NewWriter(w)
+	if src.Schema != "" {
+		bw.WriteString(src.Schema + ";\n")
+		fmt.Fprintf(bw, "DELETE FROM %s;\n", QuoteIdentifier(src.Name))
+	}
+
+	columns := rows.Columns()
 	quotedCols := make([]string, len(columns))
 	for i, col := range columns {
 		quotedCols[i] = QuoteIdentifier(col)
 	}
-	colList := strings.Join(quotedCols, ", ")
-	for _, row := range rows {
-		values := make([]string, len(columns))
-		for i, col := range columns {
-			values[i] = formatSQLValue(row[col])
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES (", QuoteIdentifier(src.Name), strings.Join(quotedCols, ", "))
+	values := make([]string, len(columns))
+	for {
+		row, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
 		}
-		buf.WriteString(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);\n", QuoteIdentifier(table), colList, strings.Join(values, ", ")))
+		for i, v := range row {
+			values[i] = formatSQLValue(v)
+		}
+		bw.WriteString(prefix)
+		bw.WriteString(strings.Join(values, ", "))
+		bw.WriteString(");\n")
 	}
+	return bw.Flush()
+}
 
-	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.sql"`, table))
-	c.Header("Content-Type", "application/sql")
-	_, err = c.Writer.Write(buf.Bytes())
-	return err
+type xlsxExporter struct{}
+
+func (xlsxExporter) ContentType() string {
+	return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+}
+func (xlsxExporter) Extension() string { return "xlsx" }
+
+func (xlsxExporter) Export(w io.Writer, src exportSource, rows rowIterator) error {
+	xw := newXLSXWriter(w)
+	if err := writeXLSXSheet(xw, src.Name, rows); err != nil {
+		return err
+	}
+	return xw.Close()
+}
+
+func writeXLSXSheet(xw *xlsxWriter, name string, rows rowIterator) error {
+	if err := xw.StartSheet(name, rows.Columns()); err != nil {
+		return err
+	}
+	for {
+		values, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := xw.WriteRow(values); err != nil {
+			return err
+		}
+	}
+}
+
+// exportWorkbook writes the given tables, or all user tables, as one
+// workbook with a sheet per table. The tables are read in one transaction
+// so the sheets are consistent with each other.
+func (s *Server) exportWorkbook(c *gin.Context, tables []string) error {
+	if len(tables) == 0 {
+		var err error
+		if tables, err = s.userTables(); err != nil {
+			return err
+		}
+	}
+	ctx := c.Request.Context()
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, s.backupBaseName()))
+	c.Header("Content-Type", xlsxExporter{}.ContentType())
+	w, err := newExportWriter(c, nil)
+	if err != nil {
+		return err
+	}
+	xw := newXLSXWriter(w)
+	for _, table := range tables {
+		rows, err := openRowStream(ctx, tx, fmt.Sprintf("SELECT * FROM %s", QuoteIdentifier(table)))
+		if err != nil {
+			return err
+		}
+		err = writeXLSXSheet(xw, table, &flushingRows{rowIterator: rows, w: w})
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := xw.Close(); err != nil {
+		return err
+	}
+	return w.close()
 }
 
 func csvValue(val interface{}) string {
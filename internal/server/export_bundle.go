@@ -0,0 +1,270 @@
+package server
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compression wraps a download in a compressed stream.
+type compression struct {
+	Name        string
+	Extension   string
+	ContentType string
+	newWriter   func(w io.Writer) (compressWriter, error)
+}
+
+// compressWriter is implemented by gzip.Writer and zstd.Encoder. Flush
+// pushes what has been compressed so far to the client.
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+var compressions = map[string]*compression{
+	"gzip": {
+		Name:        "gzip",
+		Extension:   "gz",
+		ContentType: "application/gzip",
+		newWriter: func(w io.Writer) (compressWriter, error) {
+			return gzip.NewWriter(w), nil
+		},
+	},
+	"zstd": {
+		Name:        "zstd",
+		Extension:   "zst",
+		ContentType: "application/zstd",
+		newWriter: func(w io.Writer) (compressWriter, error) {
+			return zstd.NewWriter(w)
+		},
+	},
+}
+
+// parseCompression looks up the compress parameter, responding with 400
+// if it is unknown. No compression is nil.
+func parseCompression(c *gin.Context, name string) (*compression, bool) {
+	if name == "" {
+		return nil, true
+	}
+	comp, ok := compressions[name]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "compress must be gzip or zstd"})
+		return nil, false
+	}
+	return comp, true
+}
+
+// setDownloadHeaders names the download, adding the compression suffix.
+func setDownloadHeaders(c *gin.Context, filename, contentType string, comp *compression) {
+	if comp != nil {
+		filename += "." + comp.Extension
+		contentType = comp.ContentType
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Content-Type", contentType)
+}
+
+// bundleManifest describes the contents of a zip bundle so the receiver can
+// recreate the tables before loading the files.
+type bundleManifest struct {
+	Database      string        `json:"database"`
+	CreatedAt     time.Time     `json:"createdAt"`
+	SQLiteVersion string        `json:"sqliteVersion"`
+	Format        string        `json:"format"`
+	Tables        []bundleTable `json:"tables"`
+}
+
+type bundleTable struct {
+	Name     string         `json:"name"`
+	File     string         `json:"file"`
+	Rows     int            `json:"rows"`
+	Schema   string         `json:"schema"`
+	Columns  []bundleColumn `json:"columns"`
+	Indexes  []string       `json:"indexes,omitempty"`
+	Triggers []string       `json:"triggers,omitempty"`
+}
+
+type bundleColumn struct {
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	NotNull    bool        `json:"notNull"`
+	Default    interface{} `json:"default"`
+	PrimaryKey int         `json:"primaryKey"`
+}
+
+// countingRows counts the rows an exporter reads.
+type countingRows struct {
+	rowIterator
+	n int
+}
+
+func (cr *countingRows) Next() ([]interface{}, error) {
+	values, err := cr.rowIterator.Next()
+	if err == nil {
+		cr.n++
+	}
+	return values, err
+}
+
+// exportBundle writes the given tables, or all user tables, into one zip
+// archive with a file per table in the format of e, schema.sql holding
+// the CREATE statements, and manifest.json. Everything is read in one
+// transaction so the files are consistent with each other.
+func (s *Server) exportBundle(c *gin.Context, format string, e exporter, tables []string) error {
+	if len(tables) == 0 {
+		var err error
+		if tables, err = s.userTables(); err != nil {
+			return err
+		}
+	}
+	ctx := c.Request.Context()
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	manifest := bundleManifest{
+		Database:  s.backupBaseName(),
+		CreatedAt: time.Now().UTC(),
+		Format:    format,
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&manifest.SQLiteVersion); err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	for _, table := range tables {
+		info, err := bundleTableInfo(tx, table)
+		if err != nil {
+			return err
+		}
+		info.File = bundleFileName(table, e.Extension(), used)
+		manifest.Tables = append(manifest.Tables, info)
+	}
+
+	setDownloadHeaders(c, manifest.Database+".zip", "application/zip", nil)
+	w, err := newExportWriter(c, nil)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	create := func(name string) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: manifest.CreatedAt})
+	}
+
+	for i := range manifest.Tables {
+		table := &manifest.Tables[i]
+		rows, err := openRowStream(ctx, tx, fmt.Sprintf("SELECT * FROM %s", QuoteIdentifier(table.Name)))
+		if err != nil {
+			return err
+		}
+		counter := &countingRows{rowIterator: &flushingRows{rowIterator: rows, w: w}}
+		f, err := create(table.File)
+		if err == nil {
+			err = e.Export(f, exportSource{Name: table.Name, Schema: table.Schema}, counter)
+		}
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("export %s: %w", table.Name, err)
+		}
+		table.Rows = counter.n
+	}
+
+	f, err := create("schema.sql")
+	if err != nil {
+		return err
+	}
+	for _, table := range manifest.Tables {
+		fmt.Fprintf(f, "%s;\n", table.Schema)
+		for _, stmt := range append(table.Indexes, table.Triggers...) {
+			fmt.Fprintf(f, "%s;\n", stmt)
+		}
+	}
+	if f, err = create("manifest.json"); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return w.close()
+}
+
+var bundleNameReplacer = strings.NewReplacer("/", "_", `\`, "_", ":", "_", "*", "_", "?", "_", `"`, "_", "<", "_", ">", "_", "|", "_")
+
+// bundleFileName names a table's file in the archive, replacing characters
+// Windows does not allow in file names. Table names differing only in case
+// would collide on such systems too, so a number is added to repeats.
+func bundleFileName(table, ext string, used map[string]bool) string {
+	base := bundleNameReplacer.Replace(table)
+	name := base + "." + ext
+	for i := 2; used[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s-%d.%s", base, i, ext)
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+func bundleTableInfo(tx *sql.Tx, table string) (bundleTable, error) {
+	info := bundleTable{Name: table}
+	if err := tx.QueryRow(`SELECT sql FROM sqlite_schema WHERE type = 'table' AND name = ?`, table).Scan(&info.Schema); err != nil {
+		if err == sql.ErrNoRows {
+			return info, fmt.Errorf("table %s not found", table)
+		}
+		return info, err
+	}
+
+	rows, err := tx.Query(`SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info(?) ORDER BY cid`, table)
+	if err != nil {
+		return info, err
+	}
+	for rows.Next() {
+		var col bundleColumn
+		var notNull int
+		var dflt sql.NullString
+		if err := rows.Scan(&col.Name, &col.Type, &notNull, &dflt, &col.PrimaryKey); err != nil {
+			rows.Close()
+			return info, err
+		}
+		col.NotNull = notNull != 0
+		if dflt.Valid {
+			col.Default = dflt.String
+		}
+		info.Columns = append(info.Columns, col)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return info, err
+	}
+
+	objects, err := tx.Query(`SELECT type, sql FROM sqlite_schema WHERE tbl_name = ? AND type IN ('index', 'trigger') AND sql IS NOT NULL ORDER BY rowid`, table)
+	if err != nil {
+		return info, err
+	}
+	defer objects.Close()
+	for objects.Next() {
+		var typ, stmt string
+		if err := objects.Scan(&typ, &stmt); err != nil {
+			return info, err
+		}
+		if typ == "index" {
+			info.Indexes = append(info.Indexes, stmt)
+		} else {
+			info.Triggers = append(info.Triggers, stmt)
+		}
+	}
+	return info, objects.Err()
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// csvDialect controls how csvExporter writes delimited text.
+type csvDialect struct {
+	Comma rune
+	Quote rune
+	// QuoteAll quotes every field except NULLs, so an empty string and
+	// NULL differ even with an empty NullText.
+	QuoteAll bool
+	NullText string
+	CRLF     bool
+	// BOM starts the file with a byte order mark, which Excel needs to
+	// detect UTF-8.
+	BOM bool
+	// Encoding converts the output; nil writes UTF-8.
+	Encoding     encoding.Encoding
+	EncodingName string
+	Header       bool
+	// Rename replaces column names in the header row.
+	Rename map[string]string
+}
+
+var defaultCSVDialect = csvDialect{Comma: ',', Quote: '"', Header: true}
+
+type csvExporter struct {
+	dialect csvDialect
+}
+
+// ContentType names the charset when it is not UTF-8, so browsers and
+// tools decode the file correctly.
+func (e csvExporter) ContentType() string {
+	if e.dialect.Encoding == nil {
+		return "text/csv"
+	}
+	return "text/csv; charset=" + e.dialect.EncodingName
+}
+
+func (csvExporter) Extension() string { return "csv" }
+
+// Configure reads delimiter, quote, quoteAll, null, crlf, bom, encoding,
+// header and rename (a JSON object of column to header name).
+func (e csvExporter) Configure(opts exportOptions) (exporter, error) {
+	d := defaultCSVDialect
+	var err error
+	if v, ok := opts["delimiter"]; ok {
+		if v == `\t` || v == "tab" {
+			v = "\t"
+		}
+		if utf8.RuneCountInString(v) != 1 {
+			return nil, errors.New("delimiter must be a single character")
+		}
+		d.Comma, _ = utf8.DecodeRuneInString(v)
+	}
+	if v, ok := opts["quote"]; ok {
+		if utf8.RuneCountInString(v) != 1 {
+			return nil, errors.New("quote must be a single character")
+		}
+		d.Quote, _ = utf8.DecodeRuneInString(v)
+	}
+	if d.Quote == d.Comma || d.Comma == '\n' || d.Comma == '\r' || d.Quote == '\n' || d.Quote == '\r' {
+		return nil, errors.New("invalid delimiter or quote")
+	}
+	for name, target := range map[string]*bool{"quoteAll": &d.QuoteAll, "crlf": &d.CRLF, "bom": &d.BOM, "header": &d.Header} {
+		if v, ok := opts[name]; ok && v != "" {
+			if *target, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("%s must be true or false", name)
+			}
+		}
+	}
+	d.NullText = opts["null"]
+
+	if name := opts["encoding"]; name != "" {
+		enc, err := htmlindex.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported encoding: %s", name)
+		}
+		canonical, _ := htmlindex.Name(enc)
+		if canonical != "utf-8" {
+			d.Encoding, d.EncodingName = enc, canonical
+		}
+	}
+	if d.BOM && d.Encoding != nil && d.EncodingName != "utf-16le" && d.EncodingName != "utf-16be" {
+		return nil, fmt.Errorf("a byte order mark cannot be written in %s", d.EncodingName)
+	}
+
+	if v := opts["rename"]; v != "" {
+		if err := json.Unmarshal([]byte(v), &d.Rename); err != nil {
+			return nil, errors.New("rename must be a JSON object of column to header name")
+		}
+	}
+	return csvExporter{dialect: d}, nil
+}
+
+func (e csvExporter) Export(w io.Writer, src exportSource, rows rowIterator) error {
+	d := e.dialect
+	var out io.Writer = w
+	var encoded io.WriteCloser
+	if d.Encoding != nil {
+		// Characters the encoding lacks become its replacement character
+		// instead of failing the export half way
+		encoded = transform.NewWriter(w, encoding.ReplaceUnsupported(d.Encoding.NewEncoder()))
+		out = encoded
+	}
+	bw := bufio.NewWriter(out)
+	if d.BOM {
+		bw.WriteString("\ufeff")
+	}
+
+	columns := rows.Columns()
+	fields := make([]string, len(columns))
+	nulls := make([]bool, len(columns))
+	if d.Header {
+		for i, col := range columns {
+			fields[i] = col
+			if name, ok := d.Rename[col]; ok {
+				fields[i] = name
+			}
+		}
+		d.writeRecord(bw, fields, nulls)
+	}
+	for {
+		values, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for i, v := range values {
+			nulls[i] = v == nil
+			fields[i] = csvValue(v)
+		}
+		d.writeRecord(bw, fields, nulls)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if encoded != nil {
+		return encoded.Close()
+	}
+	return nil
+}
+
+func (d csvDialect) writeRecord(w *bufio.Writer, fields []string, nulls []bool) {
+	for i, field := range fields {
+		if i > 0 {
+			w.WriteRune(d.Comma)
+		}
+		if nulls[i] {
+			w.WriteString(d.NullText)
+			continue
+		}
+		if !d.needsQuotes(field) {
+			w.WriteString(field)
+			continue
+		}
+		w.WriteRune(d.Quote)
+		for _, r := range field {
+			if r == d.Quote {
+				w.WriteRune(d.Quote)
+			}
+			w.WriteRune(r)
+		}
+		w.WriteRune(d.Quote)
+	}
+	if d.CRLF {
+		w.WriteString("\r\n")
+	} else {
+		w.WriteByte('\n')
+	}
+}
+
+// needsQuotes follows encoding/csv, and also quotes text that would read
+// back as the NULL marker.
+func (d csvDialect) needsQuotes(field string) bool {
+	if d.QuoteAll {
+		return true
+	}
+	if field == "" {
+		return false
+	}
+	if field == d.NullText || strings.ContainsRune(field, d.Comma) || strings.ContainsRune(field, d.Quote) || strings.ContainsAny(field, "\r\n") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"html"
+	"io"
+	"strings"
+)
+
+func init() {
+	registerExporter("ndjson", ndjsonExporter{})
+	registerExporter("tsv", tsvExporter{})
+	registerExporter("markdown", markdownExporter{})
+	registerExporter("html", htmlExporter{})
+}
+
+// ndjsonExporter writes one JSON object per line.
+type ndjsonExporter struct{}
+
+func (ndjsonExporter) ContentType() string { return "application/x-ndjson" }
+func (ndjsonExporter) Extension() string   { return "ndjson" }
+
+func (ndjsonExporter) Export(w io.Writer, src exportSource, rows rowIterator) error {
+	enc := newJSONRowEncoder(rows.Columns())
+	for {
+		values, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := enc.encode(w, values); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+}
+
+// tsvExporter writes tab separated values. Tabs, line breaks and
+// backslashes inside fields are escaped as \t, \n, \r and \\, and NULL is
+// written as \N, as PostgreSQL's and MySQL's text formats do.
+type tsvExporter struct{}
+
+func (tsvExporter) ContentType() string { return "text/tab-separated-values" }
+func (tsvExporter) Extension() string   { return "tsv" }
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func (tsvExporter) Export(w io.Writer, src exportSource, rows rowIterator) error {
+	bw := bufio.NewWriter(w)
+	writeLine := func(fields []string) {
+		for i, f := range fields {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+			bw.WriteString(f)
+		}
+		bw.WriteByte('\n')
+	}
+
+	columns := rows.Columns()
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = tsvEscaper.Replace(col)
+	}
+	writeLine(header)
+
+	fields := make([]string, len(columns))
+	for {
+		values, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for i, v := range values {
+			if v == nil {
+				fields[i] = `\N`
+			} else {
+				fields[i] = tsvEscaper.Replace(csvValue(v))
+			}
+		}
+		writeLine(fields)
+	}
+	return bw.Flush()
+}
+
+// markdownExporter writes a GitHub flavored Markdown table. NULL cells are
+// left empty.
+type markdownExporter struct{}
+
+func (markdownExporter) ContentType() string { return "text/markdown; charset=utf-8" }
+func (markdownExporter) Extension() string   { return "md" }
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+func (markdownExporter) Export(w io.Writer, src exportSource, rows rowIterator) error {
+	bw := bufio.NewWriter(w)
+	writeLine := func(cells []string) {
+		bw.WriteString("|")
+		for _, cell := range cells {
+			bw.WriteString(" ")
+			bw.WriteString(cell)
+			bw.WriteString(" |")
+		}
+		bw.WriteString("\n")
+	}
+
+	columns := rows.Columns()
+	header := make([]string, len(columns))
+	rule := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = markdownEscaper.Replace(col)
+		rule[i] = "---"
+	}
+	writeLine(header)
+	writeLine(rule)
+
+	cells := make([]string, len(columns))
+	for {
+		values, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for i, v := range values {
+			cells[i] = markdownEscaper.Replace(csvValue(v))
+		}
+		writeLine(cells)
+	}
+	return bw.Flush()
+}
+
+// htmlExporter writes a standalone HTML page holding the rows as a table.
+type htmlExporter struct{}
+
+func (htmlExporter) ContentType() string { return "text/html; charset=utf-8" }
+func (htmlExporter) Extension() string   { return "html" }
+
+const htmlExportStyle = `body{font-family:system-ui,-apple-system,"Segoe UI",sans-serif;margin:24px;color:#1f2328}
+h1{font-size:20px}
+table{border-collapse:collapse;font-size:13px}
+th,td{border:1px solid #d0d7de;padding:4px 8px;text-align:left;vertical-align:top;white-space:pre-wrap}
+th{background:#f6f8fa;position:sticky;top:0}
+tr:nth-child(even) td{background:#fafbfc}
+td.num{text-align:right;font-variant-numeric:tabular-nums}
+td.null{color:#8c959f;font-style:italic}`
+
+func (htmlExporter) Export(w io.Writer, src exportSource, rows rowIterator) error {
+	bw := bufio.NewWriter(w)
+	title := html.EscapeString(src.Name)
+	bw.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>" + title + "</title>\n<style>\n" + htmlExportStyle + "\n</style>\n</head>\n<body>\n")
+	bw.WriteString("<h1>" + title + "</h1>\n<table>\n<thead><tr>")
+	for _, col := range rows.Columns() {
+		bw.WriteString("<th>" + html.EscapeString(col) + "</th>")
+	}
+	bw.WriteString("</tr></thead>\n<tbody>\n")
+
+	for {
+		values, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		bw.WriteString("<tr>")
+		for _, v := range values {
+			switch v.(type) {
+			case nil:
+				bw.WriteString(`<td class="null">NULL</td>`)
+			case int64, float64:
+				bw.WriteString(`<td class="num">` + html.EscapeString(csvValue(v)) + "</td>")
+			default:
+				bw.WriteString("<td>" + html.EscapeString(csvValue(v)) + "</td>")
+			}
+		}
+		bw.WriteString("</tr>\n")
+	}
+	bw.WriteString("</tbody>\n</table>\n</body>\n</html>\n")
+	return bw.Flush()
+}
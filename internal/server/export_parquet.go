@@ -0,0 +1,466 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+func init() {
+	registerExporter("parquet", parquetExporter{})
+}
+
+const (
+	parquetMagic        = "PAR1"
+	parquetGroupRows    = 100000
+	parquetGroupBytes   = 64 << 20
+	parquetCreatedBy    = "sqliteviewer"
+	parquetTypeInt64    = 2
+	parquetTypeDouble   = 5
+	parquetTypeBinary   = 6
+	parquetOptional     = 1
+	parquetConvertedStr = 0 // UTF8
+	parquetEncPlain     = 0
+	parquetEncRLE       = 3
+	parquetCodecGzip    = 2
+	parquetDataPage     = 0
+)
+
+// parquetExporter writes an Apache Parquet file with one optional column
+// per result column. SQLite values carry their own types, so the rows are
+// first spooled to a temporary file while the narrowest type that holds
+// every value of a column is found: INT64, DOUBLE, or UTF8 text (plain
+// binary if any value is not valid UTF-8). The file is then written in
+// row groups, each column chunk as a single gzip compressed data page.
+type parquetExporter struct{}
+
+func (parquetExporter) ContentType() string { return "application/vnd.apache.parquet" }
+func (parquetExporter) Extension() string   { return "parquet" }
+
+// parquetKind orders the column types from narrowest to widest.
+type parquetKind int
+
+const (
+	parquetKindNull parquetKind = iota
+	parquetKindInt
+	parquetKindDouble
+	parquetKindText
+)
+
+type parquetColumn struct {
+	name   string
+	kind   parquetKind
+	binary bool // text with invalid UTF-8
+
+	// Current row group
+	defined []bool
+	ints    []int64
+	doubles []float64
+	texts   [][]byte
+}
+
+func (col *parquetColumn) observe(v interface{}) {
+	switch val := v.(type) {
+	case nil:
+	case int64:
+		if col.kind < parquetKindInt {
+			col.kind = parquetKindInt
+		}
+	case float64:
+		if col.kind < parquetKindDouble {
+			col.kind = parquetKindDouble
+		}
+	case string:
+		col.kind = parquetKindText
+		if !utf8.ValidString(val) {
+			col.binary = true
+		}
+	default:
+		col.kind = parquetKindText
+	}
+}
+
+func (col *parquetColumn) physicalType() int32 {
+	switch col.kind {
+	case parquetKindInt:
+		return parquetTypeInt64
+	case parquetKindDouble:
+		return parquetTypeDouble
+	}
+	return parquetTypeBinary
+}
+
+// add appends v to the current row group and returns its encoded size.
+func (col *parquetColumn) add(v interface{}) int {
+	col.defined = append(col.defined, v != nil)
+	if v == nil {
+		return 0
+	}
+	switch col.kind {
+	case parquetKindInt:
+		col.ints = append(col.ints, v.(int64))
+		return 8
+	case parquetKindDouble:
+		switch val := v.(type) {
+		case int64:
+			col.doubles = append(col.doubles, float64(val))
+		default:
+			col.doubles = append(col.doubles, val.(float64))
+		}
+		return 8
+	default:
+		text := []byte(csvValue(v))
+		col.texts = append(col.texts, text)
+		return 4 + len(text)
+	}
+}
+
+func (col *parquetColumn) reset() {
+	col.defined = col.defined[:0]
+	col.ints = col.ints[:0]
+	col.doubles = col.doubles[:0]
+	col.texts = col.texts[:0]
+}
+
+// pageBody encodes the definition levels and the PLAIN values of the
+// current row group.
+func (col *parquetColumn) pageBody() []byte {
+	levels := parquetBitPacked(col.defined)
+	body := binary.LittleEndian.AppendUint32(nil, uint32(len(levels)))
+	body = append(body, levels...)
+	switch col.kind {
+	case parquetKindInt:
+		for _, v := range col.ints {
+			body = binary.LittleEndian.AppendUint64(body, uint64(v))
+		}
+	case parquetKindDouble:
+		for _, v := range col.doubles {
+			body = binary.LittleEndian.AppendUint64(body, math.Float64bits(v))
+		}
+	default:
+		for _, v := range col.texts {
+			body = binary.LittleEndian.AppendUint32(body, uint32(len(v)))
+			body = append(body, v...)
+		}
+	}
+	return body
+}
+
+// parquetBitPacked encodes bits as one bit-packed run of the RLE/bit-packing
+// hybrid encoding with bit width 1.
+func parquetBitPacked(bits []bool) []byte {
+	groups := (len(bits) + 7) / 8
+	out := binary.AppendUvarint(nil, uint64(groups)<<1|1)
+	packed := make([]byte, groups)
+	for i, set := range bits {
+		if set {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return append(out, packed...)
+}
+
+type parquetChunk struct {
+	offset           int64
+	values           int
+	compressedSize   int64
+	uncompressedSize int64
+}
+
+type parquetRowGroup struct {
+	rows   int
+	chunks []parquetChunk
+}
+
+func (parquetExporter) Export(w io.Writer, src exportSource, rows rowIterator) error {
+	// Joins and repeated expressions can return the same column name more
+	// than once, which a Parquet schema does not allow
+	columns := make([]*parquetColumn, len(rows.Columns()))
+	used := map[string]bool{}
+	for i, name := range rows.Columns() {
+		columns[i] = &parquetColumn{name: uniqueName(used, name)}
+	}
+
+	spool, err := os.CreateTemp("", "sqliteviewer-parquet-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	spoolWriter := bufio.NewWriter(spool)
+	enc := gob.NewEncoder(spoolWriter)
+	total := 0
+	for {
+		values, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for i, v := range values {
+			// The driver returns DATE and DATETIME columns as times,
+			// which gob cannot spool as interface values
+			if t, ok := v.(time.Time); ok {
+				values[i] = t.Format(time.RFC3339Nano)
+			}
+			columns[i].observe(values[i])
+		}
+		if err := enc.Encode(values); err != nil {
+			return fmt.Errorf("spool rows: %w", err)
+		}
+		total++
+	}
+	if err := spoolWriter.Flush(); err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	pw := &parquetFileWriter{w: w}
+	pw.write([]byte(parquetMagic))
+	dec := gob.NewDecoder(bufio.NewReader(spool))
+	var groups []parquetRowGroup
+	groupRows, groupBytes := 0, 0
+	for read := 0; read < total; read++ {
+		var values []interface{}
+		if err := dec.Decode(&values); err != nil {
+			return fmt.Errorf("read spooled rows: %w", err)
+		}
+		for i, col := range columns {
+			var v interface{}
+			if i < len(values) {
+				v = values[i]
+			}
+			groupBytes += col.add(v)
+		}
+		groupRows++
+		if groupRows == parquetGroupRows || groupBytes >= parquetGroupBytes || read == total-1 {
+			group, err := pw.writeRowGroup(columns, groupRows)
+			if err != nil {
+				return err
+			}
+			groups = append(groups, group)
+			groupRows, groupBytes = 0, 0
+		}
+	}
+
+	footer := parquetFileMetaData(columns, groups, total)
+	pw.write(footer)
+	pw.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	pw.write([]byte(parquetMagic))
+	return pw.err
+}
+
+// parquetFileWriter tracks the file offset that column chunk metadata
+// refers to.
+type parquetFileWriter struct {
+	w      io.Writer
+	offset int64
+	err    error
+}
+
+func (pw *parquetFileWriter) write(b []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	pw.err = err
+}
+
+func (pw *parquetFileWriter) writeRowGroup(columns []*parquetColumn, rows int) (parquetRowGroup, error) {
+	group := parquetRowGroup{rows: rows}
+	for _, col := range columns {
+		body := col.pageBody()
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write(body)
+		if err := zw.Close(); err != nil {
+			return group, err
+		}
+		header := parquetPageHeader(len(body), compressed.Len(), rows)
+
+		chunk := parquetChunk{
+			offset:           pw.offset,
+			values:           rows,
+			compressedSize:   int64(len(header) + compressed.Len()),
+			uncompressedSize: int64(len(header) + len(body)),
+		}
+		pw.write(header)
+		pw.write(compressed.Bytes())
+		if pw.err != nil {
+			return group, pw.err
+		}
+		group.chunks = append(group.chunks, chunk)
+		col.reset()
+	}
+	return group, nil
+}
+
+func parquetPageHeader(uncompressed, compressed, values int) []byte {
+	t := newThriftWriter()
+	t.i32(1, parquetDataPage)
+	t.i32(2, int32(uncompressed))
+	t.i32(3, int32(compressed))
+	t.structBegin(5)
+	t.i32(1, int32(values))
+	t.i32(2, parquetEncPlain)
+	t.i32(3, parquetEncRLE)
+	t.i32(4, parquetEncRLE)
+	t.structEnd()
+	return t.end()
+}
+
+func parquetFileMetaData(columns []*parquetColumn, groups []parquetRowGroup, rows int) []byte {
+	t := newThriftWriter()
+	t.i32(1, 1)
+	t.listBegin(2, thriftStruct, len(columns)+1)
+	t.elemBegin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(columns)))
+	t.elemEnd()
+	for _, col := range columns {
+		t.elemBegin()
+		t.i32(1, col.physicalType())
+		t.i32(3, parquetOptional)
+		t.binary(4, col.name)
+		if col.physicalType() == parquetTypeBinary && !col.binary {
+			t.i32(6, parquetConvertedStr)
+		}
+		t.elemEnd()
+	}
+	t.i64(3, int64(rows))
+	t.listBegin(4, thriftStruct, len(groups))
+	for _, group := range groups {
+		t.elemBegin()
+		t.listBegin(1, thriftStruct, len(group.chunks))
+		var groupBytes int64
+		for i, chunk := range group.chunks {
+			col := columns[i]
+			groupBytes += chunk.uncompressedSize
+			t.elemBegin()
+			t.i64(2, chunk.offset)
+			t.structBegin(3)
+			t.i32(1, col.physicalType())
+			t.listBegin(2, thriftI32, 2)
+			t.elemI32(parquetEncPlain)
+			t.elemI32(parquetEncRLE)
+			t.listBegin(3, thriftBinary, 1)
+			t.elemBinary(col.name)
+			t.i32(4, parquetCodecGzip)
+			t.i64(5, int64(chunk.values))
+			t.i64(6, chunk.uncompressedSize)
+			t.i64(7, chunk.compressedSize)
+			t.i64(9, chunk.offset)
+			t.structEnd()
+			t.elemEnd()
+		}
+		t.i64(2, groupBytes)
+		t.i64(3, int64(group.rows))
+		t.elemEnd()
+	}
+	t.binary(6, parquetCreatedBy)
+	return t.end()
+}
+
+// Thrift compact protocol type ids.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the subset of the Thrift compact protocol that
+// Parquet metadata uses. Fields must be written in increasing id order
+// within each struct.
+type thriftWriter struct {
+	b    []byte
+	last []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	top := len(t.last) - 1
+	if delta := id - t.last[top]; delta > 0 && delta <= 15 {
+		t.b = append(t.b, byte(delta)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.b = binary.AppendVarint(t.b, int64(id))
+	}
+	t.last[top] = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.elemI32(v)
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.b = binary.AppendVarint(t.b, v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.elemBinary(s)
+}
+
+func (t *thriftWriter) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thriftWriter) structEnd() {
+	t.elemEnd()
+}
+
+func (t *thriftWriter) listBegin(id int16, elemType byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|elemType)
+	} else {
+		t.b = append(t.b, 0xf0|elemType)
+		t.b = binary.AppendUvarint(t.b, uint64(n))
+	}
+}
+
+func (t *thriftWriter) elemI32(v int32) {
+	t.b = binary.AppendVarint(t.b, int64(v))
+}
+
+func (t *thriftWriter) elemBinary(s string) {
+	t.b = binary.AppendUvarint(t.b, uint64(len(s)))
+	t.b = append(t.b, s...)
+}
+
+// elemBegin starts a struct that is a list element or a field value.
+func (t *thriftWriter) elemBegin() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) elemEnd() {
+	t.b = append(t.b, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// end closes the top level struct and returns the encoding.
+func (t *thriftWriter) end() []byte {
+	return append(t.b, 0)
+}
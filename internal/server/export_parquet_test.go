@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// sliceRows serves fixed rows to an exporter.
+type sliceRows struct {
+	columns []string
+	rows    [][]interface{}
+}
+
+func (r *sliceRows) Columns() []string { return r.columns }
+
+func (r *sliceRows) Next() ([]interface{}, error) {
+	if len(r.rows) == 0 {
+		return nil, io.EOF
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	return row, nil
+}
+
+func TestParquetExportRoundTrip(t *testing.T) {
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		columns   []string
+		rows      [][]interface{}
+		wantNames []string
+		want      [][]interface{}
+	}{
+		{
+			name:      "types",
+			columns:   []string{"i", "f", "s", "b"},
+			rows:      [][]interface{}{{int64(1), 1.5, "x", []byte{0xff}}, {nil, int64(2), nil, nil}},
+			wantNames: []string{"i", "f", "s", "b"},
+			want:      [][]interface{}{{int64(1), 1.5, "x", "\xff"}, {nil, 2.0, nil, nil}},
+		},
+		{
+			name:      "duplicate names",
+			columns:   []string{"a", "a", "A", "a_2"},
+			rows:      [][]interface{}{{int64(1), int64(2), int64(3), int64(4)}},
+			wantNames: []string{"a", "a_2", "A_3", "a_2_2"},
+			want:      [][]interface{}{{int64(1), int64(2), int64(3), int64(4)}},
+		},
+		{
+			name:      "times",
+			columns:   []string{"t"},
+			rows:      [][]interface{}{{when}},
+			wantNames: []string{"t"},
+			want:      [][]interface{}{{"2024-01-02T03:04:05Z"}},
+		},
+		{
+			name:      "no rows",
+			columns:   []string{"a"},
+			wantNames: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			src := &sliceRows{columns: tt.columns, rows: tt.rows}
+			if err := (parquetExporter{}).Export(&buf, exportSource{Name: "t"}, src); err != nil {
+				t.Fatal(err)
+			}
+			f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+
+			fields := f.Schema().Fields()
+			if len(fields) != len(tt.wantNames) {
+				t.Fatalf("got %d fields, want %d", len(fields), len(tt.wantNames))
+			}
+			// The schema orders fields by name; values follow that order
+			order := map[string]int{}
+			for i, field := range fields {
+				order[field.Name()] = i
+			}
+			for _, name := range tt.wantNames {
+				if _, ok := order[name]; !ok {
+					t.Errorf("field %q missing", name)
+				}
+			}
+			if f.NumRows() != int64(len(tt.want)) {
+				t.Fatalf("got %d rows, want %d", f.NumRows(), len(tt.want))
+			}
+
+			var got []parquet.Row
+			for _, group := range f.RowGroups() {
+				rows := group.Rows()
+				buf := make([]parquet.Row, group.NumRows())
+				n, err := rows.ReadRows(buf)
+				if err != nil && err != io.EOF {
+					t.Fatal(err)
+				}
+				got = append(got, buf[:n]...)
+				rows.Close()
+			}
+			for r, want := range tt.want {
+				for c, name := range tt.wantNames {
+					v := got[r][order[name]]
+					var value interface{}
+					switch {
+					case v.IsNull():
+					case v.Kind() == parquet.Int64:
+						value = v.Int64()
+					case v.Kind() == parquet.Double:
+						value = v.Double()
+					default:
+						value = string(v.ByteArray())
+					}
+					if value != want[c] {
+						t.Errorf("row %d %s = %#v, want %#v", r, name, value, want[c])
+					}
+				}
+			}
+		})
+	}
+}
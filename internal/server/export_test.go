@@ -0,0 +1,30 @@
+package server
+
+import "testing"
+
+func TestCheckReadQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		ok    bool
+	}{
+		{"SELECT * FROM t", true},
+		{"select a, replace(b, 'x', 'y') from t;", true},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"VALUES (1), (2)", true},
+		{"SELECT ';' AS semi -- ; trailing comment", true},
+		{"", false},
+		{";", false},
+		{"DELETE FROM t", false},
+		{"PRAGMA query_only = OFF", false},
+		{"SELECT 1; PRAGMA query_only = OFF", false},
+		{"SELECT 1; DELETE FROM t", false},
+		{"WITH x AS (SELECT 1) DELETE FROM t", false},
+		{"WITH x AS (SELECT 1) INSERT INTO t SELECT * FROM x", false},
+	}
+	for _, tt := range tests {
+		err := checkReadQuery(tt.query)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkReadQuery(%q) = %v, want ok %v", tt.query, err, tt.ok)
+		}
+	}
+}
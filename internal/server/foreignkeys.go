@@ -0,0 +1,393 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// foreignKey describes one (possibly composite) foreign key constraint.
+// From always lists the referencing (child) columns and To the referenced
+// (parent) columns. Table is the parent table for outgoing keys and the
+// child table for incoming ones.
+type foreignKey struct {
+	ID       int      `json:"id"`
+	Table    string   `json:"table"`
+	From     []string `json:"from"`
+	To       []string `json:"to"`
+	OnUpdate string   `json:"onUpdate"`
+	OnDelete string   `json:"onDelete"`
+}
+
+// foreignKeys returns the keys declared on table. References that omit the
+// parent columns are resolved to the parent's primary key.
+func (s *Server) foreignKeys(table string) ([]foreignKey, error) {
+	rows, err := s.db.Query(`SELECT id, "table", "from", "to", on_update, on_delete FROM pragma_foreign_key_list(?) ORDER BY id, seq`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []foreignKey
+	for rows.Next() {
+		var id int
+		var parent, from, onUpdate, onDelete string
+		var to sql.NullString
+		if err := rows.Scan(&id, &parent, &from, &to, &onUpdate, &onDelete); err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 || keys[len(keys)-1].ID != id {
+			keys = append(keys, foreignKey{ID: id, Table: parent, OnUpdate: onUpdate, OnDelete: onDelete})
+		}
+		fk := &keys[len(keys)-1]
+		fk.From = append(fk.From, from)
+		if to.Valid && to.String != "" {
+			fk.To = append(fk.To, to.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range keys {
+		if len(keys[i].To) == len(keys[i].From) {
+			continue
+		}
+		pk, err := s.primaryKeyColumns(keys[i].Table)
+		if err != nil {
+			return nil, err
+		}
+		keys[i].To = pk
+	}
+	return keys, nil
+}
+
+// incomingKeyCache holds the foreign keys referencing each table, found
+// by reading the keys of every table. It stays valid while the schema
+// version does.
+type incomingKeyCache struct {
+	mu      sync.Mutex
+	version int64
+	keys    map[string][]foreignKey // lower-case parent -> keys, Table is the child
+}
+
+// incomingForeignKeys returns the keys of other tables that reference table.
+func (s *Server) incomingForeignKeys(table string) ([]foreignKey, error) {
+	var version int64
+	if err := s.db.QueryRow("PRAGMA schema_version").Scan(&version); err != nil {
+		return nil, err
+	}
+	cache := s.incoming
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.keys == nil || cache.version != version {
+		tables, err := s.userTables()
+		if err != nil {
+			return nil, err
+		}
+		keys := map[string][]foreignKey{}
+		for _, child := range tables {
+			outgoing, err := s.foreignKeys(child)
+			if err != nil {
+				return nil, err
+			}
+			for _, fk := range outgoing {
+				parent := strings.ToLower(fk.Table)
+				fk.Table = child
+				keys[parent] = append(keys[parent], fk)
+			}
+		}
+		cache.keys, cache.version = keys, version
+	}
+	return append([]foreignKey(nil), cache.keys[strings.ToLower(table)]...), nil
+}
+
+func (s *Server) userTables() ([]string, error) {
+	rows, err := s.db.Query(`SELECT name FROM sqlite_schema WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+func (s *Server) primaryKeyColumns(table string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols = append(cols, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		cols = []string{"rowid"}
+	}
+	return cols, nil
+}
+
+// displayColumn picks the column used to label rows of table when they are
+// referenced elsewhere: a conventionally named column if there is one,
+// otherwise the first non-key text column. It returns "" if none fits.
+func (s *Server) displayColumn(table string) (string, error) {
+	rows, err := s.db.Query(`SELECT name, type, pk FROM pragma_table_info(?) ORDER BY cid`, table)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	preferred := map[string]bool{"name": true, "title": true, "label": true, "display_name": true, "username": true, "email": true}
+	firstText := ""
+	for rows.Next() {
+		var name, typ string
+		var pk int
+		if err := rows.Scan(&name, &typ, &pk); err != nil {
+			return "", err
+		}
+		if preferred[strings.ToLower(name)] {
+			return name, nil
+		}
+		upper := strings.ToUpper(typ)
+		isText := strings.Contains(upper, "CHAR") || strings.Contains(upper, "CLOB") || strings.Contains(upper, "TEXT")
+		if firstText == "" && pk == 0 && isText {
+			firstText = name
+		}
+	}
+	return firstText, rows.Err()
+}
+
+// maxLabelLookup caps the values bound in one label lookup query.
+const maxLabelLookup = 500
+
+// resolveReferences looks up a display label for every value of the
+// single-column foreign keys of table that appears in rows.
+func (s *Server) resolveReferences(table string, rows []map[string]interface{}) (gin.H, error) {
+	keys, err := s.foreignKeys(table)
+	if err != nil {
+		return nil, err
+	}
+	refs := gin.H{}
+	for _, fk := range keys {
+		if len(fk.From) != 1 || len(fk.To) != 1 {
+			continue
+		}
+		seen := map[string]bool{}
+		var args []interface{}
+		for _, row := range rows {
+			v := row[fk.From[0]]
+			if v == nil || seen[fmt.Sprint(v)] {
+				continue
+			}
+			seen[fmt.Sprint(v)] = true
+			args = append(args, v)
+		}
+
+		labels := map[string]interface{}{}
+		if len(args) > 0 {
+			label, err := s.displayColumn(fk.Table)
+			if err != nil {
+				return nil, err
+			}
+			labelExpr := QuoteIdentifier(fk.To[0])
+			if label != "" {
+				labelExpr = QuoteIdentifier(label)
+			}
+			// Look values up in chunks to stay below SQLite's limit on
+			// bound parameters
+			for len(args) > 0 {
+				chunk := args
+				if len(chunk) > maxLabelLookup {
+					chunk = chunk[:maxLabelLookup]
+				}
+				args = args[len(chunk):]
+				query := fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s IN (%s)",
+					QuoteIdentifier(fk.To[0]),
+					labelExpr,
+					QuoteIdentifier(fk.Table),
+					QuoteIdentifier(fk.To[0]),
+					strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", "),
+				)
+				labelRows, err := s.db.Query(query, chunk...)
+				if err != nil {
+					return nil, err
+				}
+				for labelRows.Next() {
+					var key, value interface{}
+					if err := labelRows.Scan(&key, &value); err != nil {
+						labelRows.Close()
+						return nil, err
+					}
+					labels[fmt.Sprint(normalizeValue(key))] = normalizeValue(value)
+				}
+				err = labelRows.Err()
+				labelRows.Close()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		refs[fk.From[0]] = gin.H{
+			"table":  fk.Table,
+			"column": fk.To[0],
+			"labels": labels,
+		}
+	}
+	return refs, nil
+}
+
+func (s *Server) handleGetRowReferences(c *gin.Context) {
+	table := c.Param("table")
+	if !IsSafeIdentifier(table) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid table name"})
+		return
+	}
+	rowid, err := strconv.ParseInt(c.Param("rowid"), 10, 64)
+	if err != nil || rowid <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rowid"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 {
+		limit = 50
+	}
+
+	snap, err := loadRowSnapshot(s.db, table, rowid)
+	if errors.Is(err, errRowNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "row not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	outgoingKeys, err := s.foreignKeys(table)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	outgoing := []gin.H{}
+	for _, fk := range outgoingKeys {
+		where, args, ok := matchColumns(fk.To, fk.From, snap)
+		if !ok {
+			continue
+		}
+		entry := gin.H{"foreignKey": fk, "row": nil}
+		selectSQL, err := s.selectRows(fk.Table)
+		if err != nil {
+			entry["error"] = err.Error()
+			outgoing = append(outgoing, entry)
+			continue
+		}
+		_, parents, err := queryRows(s.db, fmt.Sprintf("%s WHERE %s LIMIT 1", selectSQL, where), args...)
+		if err != nil {
+			entry["error"] = err.Error()
+		} else if len(parents) > 0 {
+			entry["row"] = parents[0]
+		}
+		outgoing = append(outgoing, entry)
+	}
+
+	incomingKeys, err := s.incomingForeignKeys(table)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	incoming := []gin.H{}
+	for _, fk := range incomingKeys {
+		where, args, ok := matchColumns(fk.From, fk.To, snap)
+		if !ok {
+			continue
+		}
+		entry := gin.H{"foreignKey": fk, "rows": []map[string]interface{}{}, "total": 0}
+		selectSQL, err := s.selectRows(fk.Table)
+		if err != nil {
+			entry["error"] = err.Error()
+			incoming = append(incoming, entry)
+			continue
+		}
+		var total int
+		if err := s.db.QueryRow(fmt.Sprintf("SELECT COUNT(1) FROM %s WHERE %s", QuoteIdentifier(fk.Table), where), args...).Scan(&total); err != nil {
+			entry["error"] = err.Error()
+			incoming = append(incoming, entry)
+			continue
+		}
+		_, children, err := queryRows(s.db, fmt.Sprintf("%s WHERE %s LIMIT ?", selectSQL, where), append(args, limit)...)
+		if err != nil {
+			entry["error"] = err.Error()
+		} else if children != nil {
+			entry["rows"] = children
+		}
+		entry["total"] = total
+		incoming = append(incoming, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"row":      snap.row(),
+		"outgoing": outgoing,
+		"incoming": incoming,
+	})
+}
+
+// selectRows returns a SELECT over all columns of table, led by the rowid
+// as _rowid unless the table is WITHOUT ROWID.
+func (s *Server) selectRows(table string) (string, error) {
+	rel, err := s.lookupRelation(table)
+	if err != nil {
+		return "", err
+	}
+	if !rel.hasRowid() {
+		return fmt.Sprintf("SELECT * FROM %s", QuoteIdentifier(table)), nil
+	}
+	return fmt.Sprintf("SELECT rowid as _rowid, * FROM %s", QuoteIdentifier(table)), nil
+}
+
+// matchColumns builds a WHERE clause matching targetCols against the values
+// of sourceCols in snap. Values are bound as scanned, so BLOB keys compare
+// as BLOBs. It reports false if any source value is NULL, since such a row
+// cannot reference or be referenced.
+func matchColumns(targetCols, sourceCols []string, snap *rowSnapshot) (string, []interface{}, bool) {
+	if len(targetCols) != len(sourceCols) {
+		return "", nil, false
+	}
+	conds := make([]string, len(targetCols))
+	args := make([]interface{}, len(targetCols))
+	for i := range targetCols {
+		col := sourceCols[i]
+		if col == "rowid" {
+			col = "_rowid"
+		}
+		v, ok := snap.value(col)
+		if !ok || v == nil {
+			return "", nil, false
+		}
+		conds[i] = fmt.Sprintf("%s = ?", QuoteIdentifier(targetCols[i]))
+		args[i] = v
+	}
+	return strings.Join(conds, " AND "), args, true
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRowReferences(t *testing.T) {
+	const schema = `
+CREATE TABLE parent(id BLOB PRIMARY KEY, name TEXT);
+CREATE TABLE child(id INTEGER PRIMARY KEY, parent_id BLOB REFERENCES parent(id));
+CREATE TABLE note(id INTEGER PRIMARY KEY, child_id REFERENCES child);
+INSERT INTO parent VALUES (X'0102', 'blob'), ('0102', 'text');
+INSERT INTO child VALUES (1, X'0102'), (2, X'0102'), (3, '0102');
+INSERT INTO note VALUES (1, 1);`
+	tests := []struct {
+		name string
+		// alter runs before the request, after a first request fills the
+		// key cache
+		alter        string
+		table        string
+		rowid        int64
+		wantOutgoing map[string]string // parent table -> label of the referenced row
+		wantIncoming map[string]int    // child table -> referencing rows
+	}{
+		{
+			name:         "blob key references blob parent",
+			table:        "child",
+			rowid:        1,
+			wantOutgoing: map[string]string{"parent": "blob"},
+			wantIncoming: map[string]int{"note": 1},
+		},
+		{
+			name:         "text key references text parent",
+			table:        "child",
+			rowid:        3,
+			wantOutgoing: map[string]string{"parent": "text"},
+			wantIncoming: map[string]int{"note": 0},
+		},
+		{
+			name:         "blob parent counts blob children",
+			table:        "parent",
+			rowid:        1,
+			wantIncoming: map[string]int{"child": 2},
+		},
+		{
+			name:         "new referencing table seen after schema change",
+			alter:        "CREATE TABLE tag(id INTEGER PRIMARY KEY, parent_id BLOB REFERENCES parent(id)); INSERT INTO tag VALUES (1, X'0102');",
+			table:        "parent",
+			rowid:        1,
+			wantIncoming: map[string]int{"child": 2, "tag": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, schema, Options{})
+			get := func() *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/api/tables/"+tt.table+"/rows/"+strconv.FormatInt(tt.rowid, 10)+"/references", nil)
+				s.router.ServeHTTP(rec, req)
+				return rec
+			}
+			if tt.alter != "" {
+				get()
+				if _, err := s.db.Exec(tt.alter); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rec := get()
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			var resp struct {
+				Outgoing []struct {
+					ForeignKey foreignKey             `json:"foreignKey"`
+					Row        map[string]interface{} `json:"row"`
+				} `json:"outgoing"`
+				Incoming []struct {
+					ForeignKey foreignKey `json:"foreignKey"`
+					Total      int        `json:"total"`
+				} `json:"incoming"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			outgoing := map[string]string{}
+			for _, o := range resp.Outgoing {
+				if o.Row != nil {
+					outgoing[o.ForeignKey.Table], _ = o.Row["name"].(string)
+				}
+			}
+			incoming := map[string]int{}
+			for _, in := range resp.Incoming {
+				incoming[in.ForeignKey.Table] = in.Total
+			}
+			if len(outgoing) != len(tt.wantOutgoing) {
+				t.Errorf("outgoing %v, want %v", outgoing, tt.wantOutgoing)
+			}
+			for table, want := range tt.wantOutgoing {
+				if outgoing[table] != want {
+					t.Errorf("outgoing %s = %q, want %q", table, outgoing[table], want)
+				}
+			}
+			if len(incoming) != len(tt.wantIncoming) {
+				t.Errorf("incoming %v, want %v", incoming, tt.wantIncoming)
+			}
+			for table, want := range tt.wantIncoming {
+				if incoming[table] != want {
+					t.Errorf("incoming %s = %d, want %d", table, incoming[table], want)
+				}
+			}
+		})
+	}
+}
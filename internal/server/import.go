@@ -0,0 +1,669 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultImportBatch = 500
+	sampleRecords      = 1000
+	maxImportErrors    = 100
+)
+
+// recordReader yields the records of an import source. Values are strings
+// for text formats and decoded JSON values otherwise.
+type recordReader interface {
+	Columns() []string
+	Next() ([]interface{}, error)
+}
+
+// importOptions are the settings shared by every import format.
+type importOptions struct {
+	Table string
+	// Create makes a new table with inferred column types if Table does
+	// not exist.
+	Create bool
+	// Conflict is abort, skip, replace or upsert.
+	Conflict  string
+	BatchSize int
+	// Mapping maps source columns to table columns. When empty, source
+	// columns are matched to table columns by name, or by position if
+	// Positional is set.
+	Mapping    map[string]string
+	Positional bool
+	// NullValue is the text that is read as NULL; nil disables the marker.
+	NullValue *string
+}
+
+type importError struct {
+	Record int    `json:"record"`
+	Error  string `json:"error"`
+}
+
+type importResult struct {
+	Table          string        `json:"table"`
+	Created        bool          `json:"created"`
+	CreateSQL      string        `json:"createSql,omitempty"`
+	Columns        []string      `json:"columns"`
+	IgnoredColumns []string      `json:"ignoredColumns,omitempty"`
+	RecordsRead    int           `json:"recordsRead"`
+	Written        int           `json:"written"`
+	Skipped        int           `json:"skipped"`
+	Errors         []importError `json:"errors,omitempty"`
+}
+
+// importColumn describes a table column an import writes to.
+type importColumn struct {
+	Name     string
+	Affinity string
+	PK       int
+}
+
+// parseImportOptions reads the form fields common to all import endpoints.
+func parseImportOptions(c *gin.Context) (importOptions, bool) {
+	opts := importOptions{
+		Table:     c.Param("table"),
+		Conflict:  c.DefaultPostForm("conflict", "abort"),
+		BatchSize: defaultImportBatch,
+	}
+	if null, ok := c.GetPostForm("null"); ok {
+		opts.NullValue = &null
+	}
+	if !IsSafeIdentifier(opts.Table) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid table name"})
+		return opts, false
+	}
+	switch opts.Conflict {
+	case "abort", "skip", "replace", "upsert":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "conflict must be abort, skip, replace or upsert"})
+		return opts, false
+	}
+	opts.Create, _ = strconv.ParseBool(c.DefaultPostForm("create", "false"))
+	if size, err := strconv.Atoi(c.DefaultPostForm("batchSize", "")); err == nil && size > 0 {
+		opts.BatchSize = size
+	}
+	if mapping := c.PostForm("mapping"); mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &opts.Mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mapping must be a JSON object of source to table columns"})
+			return opts, false
+		}
+		for _, target := range opts.Mapping {
+			if !IsSafeIdentifier(target) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid column: %s", target)})
+				return opts, false
+			}
+		}
+	}
+	return opts, true
+}
+
+// stageImportFile copies the uploaded file to a temporary file that
+// outlives the request, since the import continues as a background job.
+func stageImportFile(c *gin.Context) (string, bool) {
+	upload, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing file upload"})
+		return "", false
+	}
+	tmp, err := os.CreateTemp("", "sqliteviewer-import-*")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+	tmp.Close()
+	if err := c.SaveUploadedFile(upload, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return tmp.Name(), true
+}
+
+// countingReader tracks how many bytes have been consumed for progress
+// reporting.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// ReadAt lets zip based formats read the staged file, counting the bytes
+// the same way.
+func (cr *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	ra, ok := cr.r.(io.ReaderAt)
+	if !ok {
+		return 0, errors.New("source does not support random access")
+	}
+	n, err := ra.ReadAt(p, off)
+	cr.n += int64(n)
+	return n, err
+}
+
+// startImport opens the staged file, builds a reader for it and runs the
+// import as a background job reporting progress in bytes.
+func (s *Server) startImport(c *gin.Context, kind, path string, opts importOptions, open func(io.Reader) (recordReader, error)) {
+	info, err := os.Stat(path)
+	if err != nil {
+		os.Remove(path)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	j := s.jobs.start(kind, opts.Table, func(j *job) (interface{}, error) {
+		defer os.Remove(path)
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		counter := &countingReader{r: f}
+		src, err := open(counter)
+		if err != nil {
+			return nil, err
+		}
+		total := int(info.Size())
+		return s.importRecords(context.Background(), src, opts, func(records int) {
+			// Zip based formats may read parts of the file more than once
+			j.progress(min(int(counter.n), total), total, fmt.Sprintf("%d records", records))
+		})
+	})
+	c.JSON(http.StatusAccepted, j)
+}
+
+// recordError is a problem with a single record that does not prevent
+// reading the records after it.
+type recordError struct {
+	err error
+}
+
+func (e *recordError) Error() string {
+	return e.err.Error()
+}
+
+func (e *recordError) Unwrap() error {
+	return e.err
+}
+
+// sampledReader buffers the first records of a source so they can be
+// inspected for type inference and then replayed.
+type sampledReader struct {
+	recordReader
+	// sample holds the records read, for inference; replay also keeps
+	// the per-record errors among them so they are reported in order.
+	sample [][]interface{}
+	replay []sampledRecord
+	pos    int
+	err    error
+}
+
+type sampledRecord struct {
+	rec []interface{}
+	err error
+}
+
+func newSampledReader(src recordReader, n int) *sampledReader {
+	sr := &sampledReader{recordReader: src}
+	for len(sr.replay) < n {
+		rec, err := src.Next()
+		var recErr *recordError
+		if errors.As(err, &recErr) {
+			// A bad record does not end the source
+			sr.replay = append(sr.replay, sampledRecord{err: err})
+			continue
+		}
+		if err != nil {
+			sr.err = err
+			break
+		}
+		sr.sample = append(sr.sample, rec)
+		sr.replay = append(sr.replay, sampledRecord{rec: rec})
+	}
+	return sr
+}
+
+func (sr *sampledReader) Next() ([]interface{}, error) {
+	if sr.pos < len(sr.replay) {
+		sr.pos++
+		r := sr.replay[sr.pos-1]
+		return r.rec, r.err
+	}
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	return sr.recordReader.Next()
+}
+
+// importRecords writes all records of src into the target table in batched
+// transactions, or in a single one when conflicts abort the import.
+func (s *Server) importRecords(ctx context.Context, src recordReader, opts importOptions, progress func(records int)) (*importResult, error) {
+	result := &importResult{Table: opts.Table}
+
+	tableCols, err := s.importColumns(opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	if len(tableCols) == 0 {
+		if !opts.Create {
+			return nil, fmt.Errorf("table %s does not exist", opts.Table)
+		}
+		sampled := newSampledReader(src, sampleRecords)
+		src = sampled
+		result.CreateSQL = createTableSQL(opts.Table, src.Columns(), sampled.sample)
+		if _, err := s.db.ExecContext(ctx, result.CreateSQL); err != nil {
+			return nil, fmt.Errorf("create table: %w", err)
+		}
+		result.Created = true
+		// Column names may have been sanitized, so match by position
+		opts.Positional = true
+		if tableCols, err = s.importColumns(opts.Table); err != nil {
+			return nil, err
+		}
+	}
+
+	targets, err := mapImportColumns(src.Columns(), tableCols, opts)
+	if err != nil {
+		return nil, err
+	}
+	var insertCols []importColumn
+	for i, target := range targets {
+		if target == nil {
+			result.IgnoredColumns = append(result.IgnoredColumns, src.Columns()[i])
+			continue
+		}
+		insertCols = append(insertCols, *target)
+		result.Columns = append(result.Columns, target.Name)
+	}
+	if len(insertCols) == 0 {
+		return nil, errors.New("no source columns match the table")
+	}
+	stmtSQL, err := insertStatement(opts.Table, insertCols, opts.Conflict)
+	if err != nil {
+		return nil, err
+	}
+
+	var tx *sql.Tx
+	var stmt *sql.Stmt
+	commit := func() error {
+		if tx == nil {
+			return nil
+		}
+		stmt.Close()
+		err := tx.Commit()
+		tx, stmt = nil, nil
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			stmt.Close()
+			tx.Rollback()
+			if opts.Conflict == "abort" {
+				result.Written = 0
+			}
+		}
+	}()
+
+	pending := 0
+	for {
+		rec, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var recErr *recordError
+		if errors.As(err, &recErr) && opts.Conflict != "abort" {
+			result.RecordsRead++
+			result.Skipped++
+			if len(result.Errors) < maxImportErrors {
+				result.Errors = append(result.Errors, importError{Record: result.RecordsRead, Error: recErr.Error()})
+			}
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("record %d: %w", result.RecordsRead+1, err)
+		}
+		result.RecordsRead++
+
+		if tx == nil {
+			if tx, err = s.db.BeginTx(ctx, nil); err != nil {
+				return result, err
+			}
+			if stmt, err = tx.PrepareContext(ctx, stmtSQL); err != nil {
+				return result, err
+			}
+		}
+		args := make([]interface{}, 0, len(insertCols))
+		for i, target := range targets {
+			if target == nil {
+				continue
+			}
+			var v interface{}
+			if i < len(rec) {
+				v = coerceValue(rec[i], target.Affinity, opts.NullValue)
+			}
+			args = append(args, v)
+		}
+
+		res, err := stmt.ExecContext(ctx, args...)
+		if err != nil {
+			if opts.Conflict == "abort" {
+				return result, fmt.Errorf("record %d: %w", result.RecordsRead, err)
+			}
+			result.Skipped++
+			if len(result.Errors) < maxImportErrors {
+				result.Errors = append(result.Errors, importError{Record: result.RecordsRead, Error: err.Error()})
+			}
+		} else if affected, _ := res.RowsAffected(); affected == 0 {
+			result.Skipped++
+		} else {
+			result.Written++
+		}
+
+		// With abort the whole import is one transaction, so a failing
+		// record leaves no earlier batches behind.
+		pending++
+		if pending >= opts.BatchSize {
+			if opts.Conflict != "abort" {
+				if err := commit(); err != nil {
+					return result, err
+				}
+			}
+			pending = 0
+			progress(result.RecordsRead)
+		}
+	}
+	if err := commit(); err != nil {
+		return result, err
+	}
+	progress(result.RecordsRead)
+	return result, nil
+}
+
+// importColumns returns the writable columns of table with their type
+// affinity, or none if the table does not exist.
+func (s *Server) importColumns(table string) ([]importColumn, error) {
+	rows, err := s.db.Query(`SELECT name, type, pk, hidden FROM pragma_table_xinfo(?) ORDER BY cid`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []importColumn
+	for rows.Next() {
+		var col importColumn
+		var typ string
+		var hidden int
+		if err := rows.Scan(&col.Name, &typ, &col.PK, &hidden); err != nil {
+			return nil, err
+		}
+		if hidden != 0 {
+			continue
+		}
+		col.Affinity = columnAffinity(typ)
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
+
+// mapImportColumns returns, for each source column, the table column it is
+// written to, or nil if it is ignored.
+func mapImportColumns(source []string, table []importColumn, opts importOptions) ([]*importColumn, error) {
+	byName := map[string]*importColumn{}
+	for i := range table {
+		byName[strings.ToLower(table[i].Name)] = &table[i]
+	}
+	targets := make([]*importColumn, len(source))
+	for i, name := range source {
+		switch {
+		case len(opts.Mapping) > 0:
+			target, ok := opts.Mapping[name]
+			if !ok {
+				continue
+			}
+			col, ok := byName[strings.ToLower(target)]
+			if !ok {
+				return nil, fmt.Errorf("mapped column %s does not exist in %s", target, opts.Table)
+			}
+			targets[i] = col
+		case opts.Positional:
+			if i < len(table) {
+				targets[i] = &table[i]
+			}
+		default:
+			targets[i] = byName[strings.ToLower(name)]
+		}
+	}
+	return targets, nil
+}
+
+func insertStatement(table string, cols []importColumn, conflict string) (string, error) {
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = QuoteIdentifier(col.Name)
+	}
+	verb := "INSERT"
+	switch conflict {
+	case "skip":
+		verb = "INSERT OR IGNORE"
+	case "replace":
+		verb = "INSERT OR REPLACE"
+	}
+	stmt := fmt.Sprintf("%s INTO %s (%s) VALUES (%s)",
+		verb,
+		QuoteIdentifier(table),
+		strings.Join(names, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "),
+	)
+	if conflict != "upsert" {
+		return stmt, nil
+	}
+
+	var pk, updates []string
+	for _, col := range cols {
+		if col.PK > 0 {
+			pk = append(pk, QuoteIdentifier(col.Name))
+			continue
+		}
+		updates = append(updates, fmt.Sprintf("%s = excluded.%s", QuoteIdentifier(col.Name), QuoteIdentifier(col.Name)))
+	}
+	if len(pk) == 0 {
+		return "", errors.New("upsert requires the primary key columns to be imported")
+	}
+	if len(updates) == 0 {
+		return stmt + fmt.Sprintf(" ON CONFLICT(%s) DO NOTHING", strings.Join(pk, ", ")), nil
+	}
+	return stmt + fmt.Sprintf(" ON CONFLICT(%s) DO UPDATE SET %s", strings.Join(pk, ", "), strings.Join(updates, ", ")), nil
+}
+
+// columnAffinity applies SQLite's rules for deriving a column's type
+// affinity from its declared type.
+func columnAffinity(declared string) string {
+	t := strings.ToUpper(declared)
+	switch {
+	case strings.Contains(t, "INT"):
+		return "INTEGER"
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return "TEXT"
+	case t == "" || strings.Contains(t, "BLOB"):
+		return "BLOB"
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return "REAL"
+	default:
+		return "NUMERIC"
+	}
+}
+
+// coerceValue converts an imported value to the storage class the column
+// affinity prefers. Text that does not parse is kept as text, as SQLite
+// would. Nested JSON values are stored as JSON text.
+func coerceValue(v interface{}, affinity string, nullValue *string) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		if nullValue != nil && val == *nullValue {
+			return nil
+		}
+		switch affinity {
+		case "INTEGER", "NUMERIC":
+			if i, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64); err == nil {
+				return i
+			}
+			if f, ok := parseFinite(val); ok {
+				if affinity == "INTEGER" && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+					return int64(f)
+				}
+				return f
+			}
+		case "REAL":
+			if f, ok := parseFinite(val); ok {
+				return f
+			}
+		}
+		return val
+	case bool:
+		if val {
+			return int64(1)
+		}
+		return int64(0)
+	case float64:
+		if (affinity == "INTEGER" || affinity == "NUMERIC") && val == math.Trunc(val) && math.Abs(val) < 1<<63 {
+			return int64(val)
+		}
+		return val
+	case json.Number:
+		if i, err := val.Int64(); err == nil && affinity != "REAL" {
+			return i
+		}
+		if f, err := val.Float64(); err == nil {
+			return f
+		}
+		return val.String()
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprint(val)
+		}
+		return string(data)
+	default:
+		return val
+	}
+}
+
+// parseFinite parses s as a float, rejecting the NaN and infinity
+// spellings ParseFloat accepts, which would otherwise turn text such as
+// "nan" or "Infinity" into numbers.
+func parseFinite(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// createTableSQL builds a CREATE TABLE statement for an import target,
+// inferring each column's type from the sampled records.
+func createTableSQL(table string, columns []string, sample [][]interface{}) string {
+	defs := make([]string, len(columns))
+	used := map[string]bool{}
+	for i, name := range columns {
+		// Distinct source names may sanitize to the same one, as "a b" and
+		// "a_b" do
+		col := uniqueName(used, sanitizeColumnName(name, i))
+		defs[i] = fmt.Sprintf("%s %s", QuoteIdentifier(col), inferColumnType(sample, i))
+	}
+	return fmt.Sprintf("CREATE TABLE %s (%s)", QuoteIdentifier(table), strings.Join(defs, ", "))
+}
+
+// uniqueName returns name, or name with the first free numeric suffix if
+// used already holds it, and records the result. Names are compared
+// case-insensitively, as SQLite compares column names.
+func uniqueName(used map[string]bool, name string) string {
+	unique := name
+	for n := 2; used[strings.ToLower(unique)]; n++ {
+		unique = fmt.Sprintf("%s_%d", name, n)
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
+
+// sanitizeColumnName turns a source column name into one accepted by
+// IsSafeIdentifier, so the rest of the API can address the column.
+func sanitizeColumnName(name string, index int) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(name) {
+		if r == '_' || (r >= '0' && r <= '9') || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return fmt.Sprintf("column%d", index+1)
+	}
+	return b.String()
+}
+
+func inferColumnType(sample [][]interface{}, col int) string {
+	seen, allInt, allNum := false, true, true
+	for _, rec := range sample {
+		if col >= len(rec) || rec[col] == nil {
+			continue
+		}
+		switch v := rec[col].(type) {
+		case string:
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			seen = true
+			if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+				allInt = false
+				if _, ok := parseFinite(v); !ok {
+					allNum = false
+				}
+			}
+		case bool:
+			seen = true
+		case float64:
+			seen = true
+			if v != math.Trunc(v) {
+				allInt = false
+			}
+		case json.Number:
+			seen = true
+			if _, err := v.Int64(); err != nil {
+				allInt = false
+			}
+		default:
+			seen = true
+			allInt, allNum = false, false
+		}
+	}
+	switch {
+	case !seen:
+		return "TEXT"
+	case allInt:
+		return "INTEGER"
+	case allNum:
+		return "REAL"
+	default:
+		return "TEXT"
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// csvReader parses delimited text with a configurable delimiter and quote
+// character, which encoding/csv does not support. A zero quote disables
+// quoting.
+type csvReader struct {
+	r     *bufio.Reader
+	comma rune
+	quote rune
+	line  int
+}
+
+func newCSVReader(r io.Reader, comma, quote rune) *csvReader {
+	br := bufio.NewReader(r)
+	// Skip a UTF-8 byte order mark as written by Excel
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		br.Discard(3)
+	}
+	return &csvReader{r: br, comma: comma, quote: quote, line: 1}
+}
+
+// Read returns the next record, or io.EOF after the last one.
+func (cr *csvReader) Read() ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	quoted, started, sawInput := false, false, false
+	for {
+		r, _, err := cr.r.ReadRune()
+		if errors.Is(err, io.EOF) {
+			if quoted {
+				return nil, fmt.Errorf("line %d: unterminated quoted field", cr.line)
+			}
+			if !sawInput {
+				return nil, io.EOF
+			}
+			return append(fields, field.String()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		sawInput = true
+
+		if quoted {
+			if r == cr.quote {
+				next, _, err := cr.r.ReadRune()
+				if err == nil && next == cr.quote {
+					field.WriteRune(r)
+					continue
+				}
+				if err == nil {
+					cr.r.UnreadRune()
+				}
+				quoted = false
+				continue
+			}
+			if r == '\n' {
+				cr.line++
+			}
+			field.WriteRune(r)
+			continue
+		}
+
+		switch {
+		case r == cr.quote && cr.quote != 0 && !started:
+			quoted, started = true, true
+		case r == cr.comma:
+			fields = append(fields, field.String())
+			field.Reset()
+			started = false
+		case r == '\r' || r == '\n':
+			if r == '\r' {
+				if next, _, err := cr.r.ReadRune(); err == nil && next != '\n' {
+					cr.r.UnreadRune()
+				}
+			}
+			cr.line++
+			// Blank lines are skipped, as encoding/csv does
+			if fields == nil && field.Len() == 0 && !started {
+				sawInput = false
+				continue
+			}
+			return append(fields, field.String()), nil
+		default:
+			field.WriteRune(r)
+			started = true
+		}
+	}
+}
+
+// csvRecords adapts csvReader to recordReader.
+type csvRecords struct {
+	reader  *csvReader
+	columns []string
+	first   []string
+}
+
+func newCSVRecords(r io.Reader, comma, quote rune, header bool) (*csvRecords, error) {
+	reader := newCSVReader(r, comma, quote)
+	first, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return &csvRecords{reader: reader}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if header {
+		columns := make([]string, len(first))
+		for i, name := range first {
+			columns[i] = strings.TrimSpace(name)
+		}
+		return &csvRecords{reader: reader, columns: columns}, nil
+	}
+	columns := make([]string, len(first))
+	for i := range first {
+		columns[i] = fmt.Sprintf("column%d", i+1)
+	}
+	return &csvRecords{reader: reader, columns: columns, first: first}, nil
+}
+
+func (cr *csvRecords) Columns() []string {
+	return cr.columns
+}
+
+func (cr *csvRecords) Next() ([]interface{}, error) {
+	fields := cr.first
+	cr.first = nil
+	if fields == nil {
+		var err error
+		if fields, err = cr.reader.Read(); err != nil {
+			return nil, err
+		}
+	}
+	rec := make([]interface{}, len(fields))
+	for i, f := range fields {
+		rec[i] = f
+	}
+	return rec, nil
+}
+
+// parseCSVDialect reads the delimiter, quote and header form fields.
+func parseCSVDialect(c *gin.Context) (comma, quote rune, header bool, ok bool) {
+	delimiter := c.DefaultPostForm("delimiter", ",")
+	if delimiter == `\t` || delimiter == "tab" {
+		delimiter = "\t"
+	}
+	if utf8.RuneCountInString(delimiter) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "delimiter must be a single character"})
+		return 0, 0, false, false
+	}
+	comma, _ = utf8.DecodeRuneInString(delimiter)
+
+	quoteChar := c.DefaultPostForm("quote", `"`)
+	if utf8.RuneCountInString(quoteChar) > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quote must be a single character or empty"})
+		return 0, 0, false, false
+	}
+	if quoteChar != "" {
+		quote, _ = utf8.DecodeRuneInString(quoteChar)
+	}
+	if quote == comma || comma == '\n' || comma == '\r' {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid delimiter or quote"})
+		return 0, 0, false, false
+	}
+
+	header, err := strconv.ParseBool(c.DefaultPostForm("header", "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "header must be true or false"})
+		return 0, 0, false, false
+	}
+	return comma, quote, header, true
+}
+
+func (s *Server) handleImportTable(c *gin.Context) {
+	opts, ok := parseImportOptions(c)
+	if !ok {
+		return
+	}
+
+	switch c.DefaultPostForm("format", "csv") {
+	case "csv":
+		s.importCSV(c, opts)
+	case "json", "ndjson":
+		s.importJSON(c, opts)
+	case "xlsx":
+		s.importXLSX(c, opts)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported format"})
+	}
+}
+
+func (s *Server) importCSV(c *gin.Context, opts importOptions) {
+	// CSV cannot express NULL, so empty fields are read as NULL by default
+	if opts.NullValue == nil {
+		empty := ""
+		opts.NullValue = &empty
+	}
+	comma, quote, header, ok := parseCSVDialect(c)
+	if !ok {
+		return
+	}
+	// Without a header row, columns are taken in table order unless mapped
+	// by their generated names (column1, column2, ...)
+	opts.Positional = !header
+
+	path, ok := stageImportFile(c)
+	if !ok {
+		return
+	}
+	s.startImport(c, "import-csv", path, opts, func(r io.Reader) (recordReader, error) {
+		return newCSVRecords(r, comma, quote, header)
+	})
+}
+
+// importXLSX reads one sheet, the first unless sheet names another, with
+// the same header and mapping rules as CSV.
+func (s *Server) importXLSX(c *gin.Context, opts importOptions) {
+	header, err := strconv.ParseBool(c.DefaultPostForm("header", "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "header must be true or false"})
+		return
+	}
+	opts.Positional = !header
+	sheet := c.PostForm("sheet")
+
+	path, ok := stageImportFile(c)
+	if !ok {
+		return
+	}
+	s.startImport(c, "import-xlsx", path, opts, func(r io.Reader) (recordReader, error) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		return newXLSXRecords(r.(io.ReaderAt), info.Size(), sheet, header)
+	})
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCSVReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		comma   rune
+		quote   rune
+		want    [][]string
+		wantErr string
+	}{
+		{
+			name:  "plain",
+			input: "a,b\n1,2\n",
+			want:  [][]string{{"a", "b"}, {"1", "2"}},
+		},
+		{
+			name:  "no final newline",
+			input: "a,b\n1,2",
+			want:  [][]string{{"a", "b"}, {"1", "2"}},
+		},
+		{
+			name:  "byte order mark and CRLF",
+			input: "\xef\xbb\xbfa,b\r\n1,2\r\n",
+			want:  [][]string{{"a", "b"}, {"1", "2"}},
+		},
+		{
+			name:  "blank lines skipped",
+			input: "\na,b\n\n\r\n1,2\n\n",
+			want:  [][]string{{"a", "b"}, {"1", "2"}},
+		},
+		{
+			name:  "empty fields kept",
+			input: ",\n\"\"\n",
+			want:  [][]string{{"", ""}, {""}},
+		},
+		{
+			name:  "quoted fields",
+			input: "\"a,b\",\"say \"\"hi\"\"\"\n\"two\nlines\",x\n",
+			want:  [][]string{{"a,b", `say "hi"`}, {"two\nlines", "x"}},
+		},
+		{
+			name:  "quote inside unquoted field",
+			input: "5\" pipe,x\n",
+			want:  [][]string{{`5" pipe`, "x"}},
+		},
+		{
+			name:  "custom delimiter and quote",
+			input: "'a;b';c\n",
+			comma: ';',
+			quote: '\'',
+			want:  [][]string{{"a;b", "c"}},
+		},
+		{
+			name:  "quoting disabled",
+			input: "\"a\"\tb\n",
+			comma: '\t',
+			quote: -1,
+			want:  [][]string{{`"a"`, "b"}},
+		},
+		{
+			name:    "unterminated quote",
+			input:   "a\n\"b\nc",
+			want:    [][]string{{"a"}},
+			wantErr: "line 3: unterminated quoted field",
+		},
+		{
+			name:  "empty input",
+			input: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comma, quote := tt.comma, tt.quote
+			if comma == 0 {
+				comma = ','
+			}
+			switch quote {
+			case 0:
+				quote = '"'
+			case -1:
+				quote = 0
+			}
+			r := newCSVReader(strings.NewReader(tt.input), comma, quote)
+			var got [][]string
+			var err error
+			for {
+				var rec []string
+				if rec, err = r.Read(); err != nil {
+					break
+				}
+				got = append(got, rec)
+			}
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("error %v, want %s", err, tt.wantErr)
+				}
+			} else if !errors.Is(err, io.EOF) {
+				t.Errorf("error %v, want EOF", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVRecords(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		header      bool
+		wantColumns []string
+		wantRows    [][]interface{}
+	}{
+		{
+			name:        "header trimmed",
+			input:       " id , name\n1,x\n",
+			header:      true,
+			wantColumns: []string{"id", "name"},
+			wantRows:    [][]interface{}{{"1", "x"}},
+		},
+		{
+			name:        "generated names keep the first row",
+			input:       "1,x\n2,y\n",
+			wantColumns: []string{"column1", "column2"},
+			wantRows:    [][]interface{}{{"1", "x"}, {"2", "y"}},
+		},
+		{
+			name:   "empty",
+			header: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := newCSVRecords(strings.NewReader(tt.input), ',', '"', tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(records.Columns(), tt.wantColumns) {
+				t.Errorf("columns %q, want %q", records.Columns(), tt.wantColumns)
+			}
+			var rows [][]interface{}
+			for {
+				rec, err := records.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows = append(rows, rec)
+			}
+			if !reflect.DeepEqual(rows, tt.wantRows) {
+				t.Errorf("rows %q, want %q", rows, tt.wantRows)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxNDJSONLine = 64 << 20
+
+// jsonRecords reads a JSON array of objects or newline-delimited JSON
+// objects. Columns are the union of keys across all records.
+type jsonRecords struct {
+	columns []string
+	index   map[string]int
+	nested  bool
+
+	dec   *json.Decoder  // array input
+	lines *bufio.Scanner // NDJSON input
+}
+
+// openJSONSource detects the input style from the first non-space byte.
+func openJSONSource(r io.Reader) (*json.Decoder, *bufio.Scanner, error) {
+	br := bufio.NewReader(r)
+	// Skip a UTF-8 byte order mark
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		br.Discard(3)
+	}
+	for {
+		b, err := br.Peek(1)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+
+	if b, err := br.Peek(1); err == nil && b[0] == '[' {
+		dec := json.NewDecoder(br)
+		dec.UseNumber()
+		if _, err := dec.Token(); err != nil {
+			return nil, nil, err
+		}
+		return dec, nil, nil
+	}
+	lines := bufio.NewScanner(br)
+	lines.Buffer(make([]byte, 0, 64*1024), maxNDJSONLine)
+	return nil, lines, nil
+}
+
+// scanJSONKeys collects the keys of every object in the file at path, in
+// order of first appearance, so records may differ in the keys they use.
+func scanJSONKeys(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	src := &jsonRecords{index: map[string]int{}}
+	if src.dec, src.lines, err = openJSONSource(f); err != nil {
+		return nil, err
+	}
+	for {
+		raw, err := src.nextRaw()
+		if errors.Is(err, io.EOF) {
+			return src.columns, nil
+		}
+		var recErr *recordError
+		if errors.As(err, &recErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys, err := objectKeys(raw)
+		if err != nil {
+			continue
+		}
+		for _, key := range keys {
+			src.addColumn(key)
+		}
+	}
+}
+
+func newJSONRecords(r io.Reader, columns []string, nested bool) (*jsonRecords, error) {
+	src := &jsonRecords{index: map[string]int{}, nested: nested}
+	for _, col := range columns {
+		src.addColumn(col)
+	}
+	var err error
+	if src.dec, src.lines, err = openJSONSource(r); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+func (jr *jsonRecords) addColumn(key string) {
+	if _, ok := jr.index[key]; ok {
+		return
+	}
+	jr.index[key] = len(jr.columns)
+	jr.columns = append(jr.columns, key)
+}
+
+func (jr *jsonRecords) Columns() []string {
+	return jr.columns
+}
+
+// objectKeys returns the keys of a JSON object in document order.
+func objectKeys(raw json.RawMessage) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("record is not a JSON object")
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// nextRaw reads the next value without decoding it. Malformed NDJSON lines
+// are returned as recordError so the import can skip them; a malformed
+// array ends the import.
+func (jr *jsonRecords) nextRaw() (json.RawMessage, error) {
+	var raw json.RawMessage
+	if jr.dec != nil {
+		if !jr.dec.More() {
+			return nil, io.EOF
+		}
+		if err := jr.dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+	} else {
+		for {
+			if !jr.lines.Scan() {
+				if err := jr.lines.Err(); err != nil {
+					return nil, err
+				}
+				return nil, io.EOF
+			}
+			line := bytes.TrimSpace(jr.lines.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			if !json.Valid(line) {
+				return nil, &recordError{fmt.Errorf("line is not valid JSON: %.40s", line)}
+			}
+			raw = append(raw, line...)
+			break
+		}
+	}
+	return raw, nil
+}
+
+// nextObject decodes the next record; values that are not objects are
+// returned as recordError.
+func (jr *jsonRecords) nextObject() (map[string]interface{}, error) {
+	raw, err := jr.nextRaw()
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil || obj == nil {
+		return nil, &recordError{errors.New("record is not a JSON object")}
+	}
+	return obj, nil
+}
+
+func (jr *jsonRecords) Next() ([]interface{}, error) {
+	obj, err := jr.nextObject()
+	if err != nil {
+		return nil, err
+	}
+	rec := make([]interface{}, len(jr.columns))
+	for key, v := range obj {
+		i, ok := jr.index[key]
+		if !ok {
+			continue
+		}
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			if !jr.nested {
+				continue
+			}
+		}
+		rec[i] = v
+	}
+	return rec, nil
+}
+
+func (s *Server) importJSON(c *gin.Context, opts importOptions) {
+	var nested bool
+	switch c.DefaultPostForm("nested", "json") {
+	case "json":
+		nested = true
+	case "skip":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nested must be json or skip"})
+		return
+	}
+	path, ok := stageImportFile(c)
+	if !ok {
+		return
+	}
+	s.startImport(c, "import-json", path, opts, func(r io.Reader) (recordReader, error) {
+		keys, err := scanJSONKeys(path)
+		if err != nil {
+			return nil, fmt.Errorf("read JSON: %w", err)
+		}
+		return newJSONRecords(r, keys, nested)
+	})
+}
@@ -0,0 +1,576 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxRecentQueries = 50
+
+// recentQueries remembers the latest read queries run in the SQL console,
+// most recent first, so indexes can be suggested for them.
+type recentQueries struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (r *recentQueries) add(query string) {
+	query = strings.TrimSpace(query)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := []string{query}
+	for _, q := range r.list {
+		if q != query && len(list) < maxRecentQueries {
+			list = append(list, q)
+		}
+	}
+	r.list = list
+}
+
+func (r *recentQueries) all() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.list...)
+}
+
+// indexSuggestion is an index that turns a full scan in a query plan into
+// an index lookup.
+type indexSuggestion struct {
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+	SQL     string   `json:"sql"`
+	// Reason is the plan step the index replaces.
+	Reason    string   `json:"reason"`
+	PlanAfter []string `json:"planAfter"`
+}
+
+type queryAdvice struct {
+	Query       string            `json:"query"`
+	Plan        []string          `json:"plan"`
+	Suggestions []indexSuggestion `json:"suggestions"`
+	Error       string            `json:"error,omitempty"`
+}
+
+var (
+	scanStepPattern      = regexp.MustCompile(`^SCAN (\S+)$`)
+	automaticStepPattern = regexp.MustCompile(`^SEARCH (\S+) USING AUTOMATIC (?:PARTIAL )?(?:COVERING )?INDEX \((.+)\)$`)
+	automaticTermPattern = regexp.MustCompile(`^(.+?)(=|>|<|>=|<=)\?$`)
+)
+
+// handleSuggestIndexes runs EXPLAIN QUERY PLAN on the given queries, or
+// the recent ones from the SQL console, and proposes indexes for tables
+// that are scanned in full or get an automatic index. Each candidate is
+// tried on an empty copy of the schema, with the statistics of
+// sqlite_stat1, and kept only if the planner then uses it.
+func (s *Server) handleSuggestIndexes(c *gin.Context) {
+	var req struct {
+		Queries []string `json:"queries"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+			return
+		}
+	}
+	if len(req.Queries) == 0 {
+		req.Queries = s.queries.all()
+	}
+
+	sandbox, err := s.schemaSandbox()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer sandbox.Close()
+
+	advice := []queryAdvice{}
+	suggestions := []indexSuggestion{}
+	seen := map[string]bool{}
+	for _, query := range req.Queries {
+		a := suggestIndexes(sandbox, query)
+		for _, sg := range a.Suggestions {
+			if !seen[sg.SQL] {
+				seen[sg.SQL] = true
+				suggestions = append(suggestions, sg)
+			}
+		}
+		advice = append(advice, a)
+	}
+	c.JSON(http.StatusOK, gin.H{"queries": advice, "suggestions": suggestions})
+}
+
+// schemaSandbox creates an in-memory database with the schema and planner
+// statistics of the open one but none of its rows.
+func (s *Server) schemaSandbox() (*sql.DB, error) {
+	sandbox, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	// Every connection to :memory: is a database of its own
+	sandbox.SetMaxOpenConns(1)
+
+	rows, err := s.db.Query(`SELECT sql FROM sqlite_schema WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY rowid`)
+	if err != nil {
+		sandbox.Close()
+		return nil, err
+	}
+	var stmts []string
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			rows.Close()
+			sandbox.Close()
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	rows.Close()
+	for _, stmt := range stmts {
+		// Shadow tables already exist once their virtual table is
+		// created, and virtual tables of unknown modules cannot be; the
+		// planner does not need either
+		sandbox.Exec(stmt)
+	}
+
+	var hasStats int
+	if err := s.db.QueryRow(`SELECT count(*) FROM sqlite_schema WHERE name = 'sqlite_stat1'`).Scan(&hasStats); err != nil || hasStats == 0 {
+		return sandbox, nil
+	}
+	stats, err := s.db.Query(`SELECT tbl, idx, stat FROM sqlite_stat1`)
+	if err != nil {
+		return sandbox, nil
+	}
+	defer stats.Close()
+	if _, err := sandbox.Exec("ANALYZE"); err != nil {
+		return sandbox, nil
+	}
+	for stats.Next() {
+		var tbl, idx, stat sql.NullString
+		if stats.Scan(&tbl, &idx, &stat) == nil {
+			sandbox.Exec(`INSERT INTO sqlite_stat1 VALUES (?, ?, ?)`, tbl, idx, stat)
+		}
+	}
+	// Load the copied statistics into the planner
+	sandbox.Exec("ANALYZE sqlite_schema")
+	return sandbox, nil
+}
+
+// explainQueryPlan returns the detail column of EXPLAIN QUERY PLAN.
+// Parameters are bound to NULL, which leaves the plan as it would be for
+// any value.
+func explainQueryPlan(db *sql.DB, query string) ([]string, error) {
+	rows, err := db.Query("EXPLAIN QUERY PLAN "+query, nullParams(tokenizeSQL(query))...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return nil, err
+		}
+		plan = append(plan, detail)
+	}
+	return plan, rows.Err()
+}
+
+// nullParams returns NULL arguments for the parameters among tokens:
+// enough positional ones for every ? and ?NNN, and a named one for each
+// :name, @name and $name.
+func nullParams(tokens []sqlToken) []interface{} {
+	var args []interface{}
+	positional, highest := 0, 0
+	named := map[string]bool{}
+	for _, t := range tokens {
+		switch {
+		case t.text == "":
+		case t.text[0] == '?' && t.kind == tokenNumber:
+			positional++
+			if n, err := strconv.Atoi(t.text[1:]); err == nil && n > highest {
+				highest = n
+			}
+		case (t.text[0] == ':' || t.text[0] == '@') && t.kind == tokenNumber,
+			t.text[0] == '$' && t.kind == tokenWord:
+			if name := t.text[1:]; name != "" && !named[name] {
+				named[name] = true
+				args = append(args, sql.Named(name, nil))
+			}
+		}
+	}
+	for i := 0; i < positional+highest; i++ {
+		args = append(args, nil)
+	}
+	return args
+}
+
+func suggestIndexes(sandbox *sql.DB, query string) queryAdvice {
+	a := queryAdvice{Query: query, Suggestions: []indexSuggestion{}}
+	upper := strings.ToUpper(strings.TrimSpace(query))
+	if !strings.HasPrefix(upper, "SELECT") && !strings.HasPrefix(upper, "WITH") {
+		a.Error = "only SELECT queries are analyzed"
+		return a
+	}
+	plan, err := explainQueryPlan(sandbox, query)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	a.Plan = plan
+
+	tokens := tokenizeSQL(query)
+	refs := tableReferences(tokens)
+	for _, step := range plan {
+		var alias string
+		var columns []string
+		if m := automaticStepPattern.FindStringSubmatch(step); m != nil {
+			alias = m[1]
+			for _, term := range strings.Split(m[2], " AND ") {
+				if t := automaticTermPattern.FindStringSubmatch(term); t != nil {
+					columns = append(columns, t[1])
+				}
+			}
+		} else if m := scanStepPattern.FindStringSubmatch(step); m != nil {
+			alias = m[1]
+		} else {
+			continue
+		}
+
+		table := refs[strings.ToLower(alias)]
+		if table == "" {
+			table = alias
+		}
+		tableColumns, err := sandboxColumns(sandbox, table)
+		if err != nil || len(tableColumns) == 0 {
+			// A view, CTE or subquery
+			continue
+		}
+		if columns == nil {
+			var names []string
+			for name, target := range refs {
+				if strings.EqualFold(target, table) {
+					names = append(names, name)
+				}
+			}
+			columns = filterColumns(tokens, names, tableColumns, len(distinctTables(refs)) == 1)
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		if sg, ok := tryIndex(sandbox, query, table, columns); ok {
+			sg.Reason = step
+			a.Suggestions = append(a.Suggestions, sg)
+		}
+	}
+	return a
+}
+
+func distinctTables(refs map[string]string) map[string]bool {
+	tables := map[string]bool{}
+	for _, table := range refs {
+		tables[strings.ToLower(table)] = true
+	}
+	return tables
+}
+
+// sandboxColumns maps lower-cased column names of table to their names.
+func sandboxColumns(sandbox *sql.DB, table string) (map[string]string, error) {
+	rows, err := sandbox.Query(`SELECT name FROM pragma_table_info(?1) WHERE EXISTS (SELECT 1 FROM sqlite_schema WHERE type = 'table' AND name = ?1)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := map[string]string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[strings.ToLower(name)] = name
+	}
+	return columns, rows.Err()
+}
+
+// tryIndex creates the index in the sandbox and keeps it as a suggestion if
+// the plan of query then uses it.
+func tryIndex(sandbox *sql.DB, query, table string, columns []string) (indexSuggestion, bool) {
+	def := indexDefinition{Table: table}
+	for _, col := range columns {
+		def.Columns = append(def.Columns, indexColumn{Name: col})
+	}
+	stmt, err := createIndexSQL(&def)
+	if err != nil {
+		return indexSuggestion{}, false
+	}
+	if _, err := sandbox.Exec(stmt); err != nil {
+		return indexSuggestion{}, false
+	}
+	defer sandbox.Exec("DROP INDEX " + QuoteIdentifier(def.Name))
+
+	plan, err := explainQueryPlan(sandbox, query)
+	if err != nil {
+		return indexSuggestion{}, false
+	}
+	for _, step := range plan {
+		if strings.HasSuffix(step, "INDEX "+def.Name) || strings.Contains(step, "INDEX "+def.Name+" ") {
+			return indexSuggestion{Table: table, Columns: columns, SQL: stmt, PlanAfter: plan}, true
+		}
+	}
+	return indexSuggestion{}, false
+}
+
+// filterColumns finds the columns of a table that WHERE, ON and HAVING
+// clauses compare: equality comparisons first, then the first range
+// comparison, the order in which an index serves them. names are the
+// table's name and aliases in the query; unqualified columns count only if
+// bare is set, meaning the query has no other table they could belong to.
+func filterColumns(tokens []sqlToken, names []string, columns map[string]string, bare bool) []string {
+	var equal, ranged []string
+	add := func(list *[]string, col string) {
+		for _, c := range equal {
+			if c == col {
+				return
+			}
+		}
+		for _, c := range *list {
+			if c == col {
+				return
+			}
+		}
+		*list = append(*list, col)
+	}
+	isName := func(t sqlToken) bool {
+		for _, n := range names {
+			if strings.EqualFold(t.text, n) {
+				return true
+			}
+		}
+		return false
+	}
+
+	filtering := false
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.kind == tokenWord {
+			switch strings.ToUpper(t.text) {
+			case "WHERE", "ON", "HAVING":
+				filtering = true
+				continue
+			case "SELECT", "FROM", "JOIN", "GROUP", "ORDER", "LIMIT", "UNION", "EXCEPT", "INTERSECT", "WINDOW":
+				filtering = false
+				continue
+			}
+		}
+		if !filtering || !t.isName() {
+			continue
+		}
+
+		start, end := i, i
+		var column string
+		if i+2 < len(tokens) && tokens[i+1].text == "." && tokens[i+2].isName() {
+			if !isName(t) {
+				i += 2
+				continue
+			}
+			column, end = tokens[i+2].text, i+2
+		} else if bare && (i == 0 || tokens[i-1].text != ".") && (i+1 == len(tokens) || tokens[i+1].text != "(") {
+			column = t.text
+		}
+		i = end
+		name, ok := columns[strings.ToLower(column)]
+		if !ok {
+			continue
+		}
+		var next, prev string
+		if end+1 < len(tokens) {
+			next = strings.ToUpper(tokens[end+1].text)
+		}
+		if start > 0 {
+			prev = strings.ToUpper(tokens[start-1].text)
+		}
+		switch {
+		case next == "=" || next == "==" || next == "IS" || next == "IN" || prev == "=" || prev == "==":
+			add(&equal, name)
+		case next == "<" || next == ">" || next == "<=" || next == ">=" || next == "BETWEEN" || next == "LIKE" || next == "GLOB" ||
+			prev == "<" || prev == ">" || prev == "<=" || prev == ">=":
+			add(&ranged, name)
+		}
+	}
+	if len(ranged) > 0 {
+		equal = append(equal, ranged[0])
+	}
+	return equal
+}
+
+// tableReferences maps the lower-cased names and aliases that FROM and JOIN
+// clauses give tables to the table names.
+func tableReferences(tokens []sqlToken) map[string]string {
+	refs := map[string]string{}
+	inFrom := false
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		word := strings.ToUpper(t.text)
+		expectTable := false
+		switch {
+		case t.kind == tokenWord && (word == "FROM" || word == "JOIN"):
+			inFrom, expectTable = true, true
+		case t.text == "," && inFrom:
+			expectTable = true
+		case t.kind == tokenWord && isClauseKeyword(word), t.text == ")", t.text == "(":
+			inFrom = false
+		}
+		if !expectTable || i+1 >= len(tokens) || !tokens[i+1].isName() {
+			continue
+		}
+		i++
+		table := tokens[i].text
+		// schema.table
+		if i+2 < len(tokens) && tokens[i+1].text == "." && tokens[i+2].isName() {
+			i += 2
+			table = tokens[i].text
+		}
+		refs[strings.ToLower(table)] = table
+		if i+1 < len(tokens) && strings.EqualFold(tokens[i+1].text, "AS") {
+			i++
+		}
+		if i+1 < len(tokens) && tokens[i+1].isName() {
+			i++
+			refs[strings.ToLower(tokens[i].text)] = table
+		}
+	}
+	return refs
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenQuoted
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+type sqlToken struct {
+	kind tokenKind
+	text string
+}
+
+// isName tells whether the token can name a table or column: a quoted
+// identifier or a word that is not a keyword.
+func (t sqlToken) isName() bool {
+	return t.kind == tokenQuoted || (t.kind == tokenWord && !sqlKeywords[strings.ToUpper(t.text)])
+}
+
+var sqlKeywords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`ALL AND AS ASC BETWEEN BY CASE CAST COLLATE CROSS CURRENT_DATE CURRENT_TIME
+		CURRENT_TIMESTAMP DESC DISTINCT ELSE END ESCAPE EXCEPT EXISTS FILTER FROM FULL GLOB GROUP HAVING IN INDEXED
+		INNER INTERSECT IS ISNULL JOIN LEFT LIKE LIMIT MATCH NATURAL NOT NOTNULL NULL OFFSET ON OR ORDER OUTER OVER
+		RECURSIVE REGEXP RIGHT SELECT THEN UNION USING VALUES WHEN WHERE WINDOW WITH`) {
+		sqlKeywords[w] = true
+	}
+}
+
+func isClauseKeyword(word string) bool {
+	switch word {
+	case "WHERE", "ON", "USING", "GROUP", "ORDER", "HAVING", "LIMIT", "UNION", "EXCEPT", "INTERSECT", "WINDOW", "SELECT":
+		return true
+	}
+	return false
+}
+
+// tokenizeSQL splits a statement into words, quoted identifiers, literals
+// and symbols, dropping comments. It is only as exact as suggesting
+// indexes needs.
+func tokenizeSQL(query string) []sqlToken {
+	var tokens []sqlToken
+	r := []rune(query)
+	isWord := func(c rune) bool {
+		return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c > 127
+	}
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && i+1 < len(r) && r[i+1] == '-':
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(r) && r[i+1] == '*':
+			i += 2
+			for i+1 < len(r) && !(r[i] == '*' && r[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			var b strings.Builder
+			j := i + 1
+			for j < len(r) {
+				if r[j] == end {
+					// A doubled quote stands for itself
+					if end != ']' && j+1 < len(r) && r[j+1] == end {
+						b.WriteRune(end)
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteRune(r[j])
+				j++
+			}
+			kind := tokenQuoted
+			if c == '\'' {
+				kind = tokenString
+			}
+			tokens = append(tokens, sqlToken{kind, b.String()})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(r) && (isWord(r[j]) || r[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{tokenNumber, string(r[i:j])})
+			i = j
+		case isWord(c):
+			j := i
+			for j < len(r) && isWord(r[j]) {
+				j++
+			}
+			tokens = append(tokens, sqlToken{tokenWord, string(r[i:j])})
+			i = j
+		case c == '?' || c == ':' || c == '@':
+			// Parameters
+			j := i + 1
+			for j < len(r) && isWord(r[j]) {
+				j++
+			}
+			tokens = append(tokens, sqlToken{tokenNumber, string(r[i:j])})
+			i = j
+		default:
+			text := string(c)
+			if i+1 < len(r) {
+				switch pair := string(r[i : i+2]); pair {
+				case "<=", ">=", "<>", "!=", "==", "||", "<<", ">>":
+					text = pair
+				}
+			}
+			tokens = append(tokens, sqlToken{tokenSymbol, text})
+			i += len([]rune(text))
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// indexDefinition describes an index to create.
+type indexDefinition struct {
+	// Name defaults to idx_<table>_<columns>.
+	Name    string        `json:"name"`
+	Table   string        `json:"table"`
+	Columns []indexColumn `json:"columns"`
+	Unique  bool          `json:"unique"`
+	// Where makes a partial index.
+	Where       string `json:"where"`
+	IfNotExists bool   `json:"ifNotExists"`
+}
+
+// indexColumn is a column or, with Expr, an expression of an index.
+type indexColumn struct {
+	Name    string `json:"name,omitempty"`
+	Expr    string `json:"expr,omitempty"`
+	Collate string `json:"collate,omitempty"`
+	Desc    bool   `json:"desc,omitempty"`
+}
+
+// createIndexSQL validates def, naming it if needed, and generates its
+// CREATE INDEX statement.
+func createIndexSQL(def *indexDefinition) (string, error) {
+	if !IsSafeIdentifier(def.Table) {
+		return "", fmt.Errorf("invalid table name: %s", def.Table)
+	}
+	if len(def.Columns) == 0 {
+		return "", errors.New("an index needs at least one column or expression")
+	}
+	terms := make([]string, len(def.Columns))
+	var nameParts []string
+	for i, col := range def.Columns {
+		switch {
+		case col.Expr != "":
+			if err := checkSQLExpression(col.Expr); err != nil {
+				return "", fmt.Errorf("index expression: %w", err)
+			}
+			terms[i] = "(" + col.Expr + ")"
+			nameParts = append(nameParts, "expr")
+		case IsSafeIdentifier(col.Name):
+			terms[i] = QuoteIdentifier(col.Name)
+			nameParts = append(nameParts, col.Name)
+		default:
+			return "", fmt.Errorf("invalid column name: %s", col.Name)
+		}
+		if col.Collate != "" {
+			if !IsSafeIdentifier(col.Collate) {
+				return "", fmt.Errorf("invalid collation: %s", col.Collate)
+			}
+			terms[i] += " COLLATE " + col.Collate
+		}
+		if col.Desc {
+			terms[i] += " DESC"
+		}
+	}
+	if def.Name == "" {
+		def.Name = indexName(def.Table, nameParts)
+	}
+	if !IsSafeIdentifier(def.Name) {
+		return "", fmt.Errorf("invalid index name: %s", def.Name)
+	}
+	if strings.HasPrefix(strings.ToLower(def.Name), "sqlite_") {
+		return "", errors.New("index names starting with sqlite_ are reserved")
+	}
+
+	stmt := "CREATE "
+	if def.Unique {
+		stmt += "UNIQUE "
+	}
+	stmt += "INDEX "
+	if def.IfNotExists {
+		stmt += "IF NOT EXISTS "
+	}
+	stmt += fmt.Sprintf("%s ON %s (%s)", QuoteIdentifier(def.Name), QuoteIdentifier(def.Table), strings.Join(terms, ", "))
+	if def.Where != "" {
+		if err := checkSQLExpression(def.Where); err != nil {
+			return "", fmt.Errorf("where clause: %w", err)
+		}
+		stmt += " WHERE " + def.Where
+	}
+	return stmt, nil
+}
+
+// indexName builds idx_<table>_<columns>, keeping only characters
+// IsSafeIdentifier accepts.
+func indexName(table string, columns []string) string {
+	name := "idx_" + table + "_" + strings.Join(columns, "_")
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= '0' && r <= '9') || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func (s *Server) handleCreateIndex(c *gin.Context) {
+	var req struct {
+		indexDefinition
+		DryRun bool `json:"dryRun"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+		return
+	}
+	stmt, err := createIndexSQL(&req.indexDefinition)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"sql": stmt, "name": req.Name, "dryRun": true})
+		return
+	}
+	if _, err := s.db.Exec(stmt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "sql": stmt})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"sql": stmt, "name": req.Name})
+}
+
+func (s *Server) handleDropIndex(c *gin.Context) {
+	name := c.Param("index")
+	if !IsSafeIdentifier(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid index name"})
+		return
+	}
+	var stmt sql.NullString
+	err := s.db.QueryRow(`SELECT sql FROM sqlite_schema WHERE type = 'index' AND name = ?`, name).Scan(&stmt)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "index not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Indexes without SQL back a PRIMARY KEY or UNIQUE constraint
+	if !stmt.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "index belongs to a table constraint; change the table schema instead"})
+		return
+	}
+	if _, err := s.db.Exec("DROP INDEX " + QuoteIdentifier(name)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dropped": name, "sql": stmt.String})
+}
+
+// indexKeyColumn is a row of PRAGMA index_xinfo.
+type indexKeyColumn struct {
+	Seq int `json:"seqno"`
+	// CID is -1 for the rowid and -2 for an expression.
+	CID     int    `json:"cid"`
+	Name    string `json:"name,omitempty"`
+	Desc    bool   `json:"desc"`
+	Collate string `json:"collate"`
+	// Key is false for the auxiliary columns stored after the key.
+	Key bool `json:"key"`
+}
+
+// handleGetIndex describes an index with PRAGMA index_list and
+// index_xinfo.
+func (s *Server) handleGetIndex(c *gin.Context) {
+	name := c.Param("index")
+	if !IsSafeIdentifier(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid index name"})
+		return
+	}
+	var table string
+	var stmt sql.NullString
+	err := s.db.QueryRow(`SELECT tbl_name, sql FROM sqlite_schema WHERE type = 'index' AND name = ?`, name).Scan(&table, &stmt)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "index not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var unique, partial int
+	var origin string
+	if err := s.db.QueryRow(`SELECT "unique", origin, partial FROM pragma_index_list(?) WHERE name = ?`, table, name).Scan(&unique, &origin, &partial); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := s.db.Query(`SELECT seqno, cid, name, "desc", coll, "key" FROM pragma_index_xinfo(?) ORDER BY seqno`, name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	columns := []indexKeyColumn{}
+	for rows.Next() {
+		var col indexKeyColumn
+		var colName sql.NullString
+		var desc, key int
+		if err := rows.Scan(&col.Seq, &col.CID, &colName, &desc, &col.Collate, &key); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		col.Name, col.Desc, col.Key = colName.String, desc != 0, key != 0
+		columns = append(columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// origin is c for CREATE INDEX, u for UNIQUE and pk for PRIMARY KEY
+	c.JSON(http.StatusOK, gin.H{
+		"name":    name,
+		"table":   table,
+		"sql":     stmt.String,
+		"unique":  unique != 0,
+		"partial": partial != 0,
+		"origin":  origin,
+		"columns": columns,
+	})
+}
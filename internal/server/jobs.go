@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxFinishedJobs = 50
+
+// job is a long-running operation executed in the background so it is not
+// bound to the lifetime of the HTTP request that started it.
+type job struct {
+	ID         string      `json:"id"`
+	Kind       string      `json:"kind"`
+	Target     string      `json:"target,omitempty"`
+	Status     string      `json:"status"`
+	Progress   string      `json:"progress,omitempty"`
+	Done       int         `json:"done"`
+	Total      int         `json:"total"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	StartedAt  *time.Time  `json:"startedAt,omitempty"`
+	FinishedAt *time.Time  `json:"finishedAt,omitempty"`
+	DurationMs int64       `json:"durationMs"`
+	Error      string      `json:"error,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+
+	manager *jobManager
+}
+
+// progress records how far the job has come. It is safe to call from the
+// job's goroutine while status requests read the job.
+func (j *job) progress(done, total int, msg string) {
+	j.manager.mu.Lock()
+	defer j.manager.mu.Unlock()
+	j.Done, j.Total, j.Progress = done, total, msg
+}
+
+type jobManager struct {
+	mu     sync.Mutex
+	run    sync.Mutex
+	nextID int
+	jobs   map[string]*job
+	order  []string
+}
+
+func newJobManager() *jobManager {
+	return &jobManager{jobs: map[string]*job{}}
+}
+
+// start queues fn as a new job. Jobs run one at a time, since most of them
+// need exclusive use of the database file.
+func (m *jobManager) start(kind, target string, fn func(j *job) (interface{}, error)) job {
+	m.mu.Lock()
+	m.nextID++
+	j := &job{
+		ID:        fmt.Sprintf("%d", m.nextID),
+		Kind:      kind,
+		Target:    target,
+		Status:    "queued",
+		CreatedAt: time.Now(),
+		manager:   m,
+	}
+	m.jobs[j.ID] = j
+	m.order = append(m.order, j.ID)
+	m.prune()
+	snapshot := *j
+	m.mu.Unlock()
+
+	go func() {
+		m.run.Lock()
+		defer m.run.Unlock()
+
+		m.mu.Lock()
+		started := time.Now()
+		j.StartedAt = &started
+		j.Status = "running"
+		m.mu.Unlock()
+
+		result, err := fn(j)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		finished := time.Now()
+		j.FinishedAt = &finished
+		j.DurationMs = finished.Sub(started).Milliseconds()
+		j.Result = result
+		if err != nil {
+			j.Status = "failed"
+			j.Error = err.Error()
+		} else {
+			j.Status = "succeeded"
+		}
+	}()
+	return snapshot
+}
+
+// prune drops the oldest finished jobs beyond maxFinishedJobs. The caller
+// must hold m.mu.
+func (m *jobManager) prune() {
+	finished := 0
+	for _, id := range m.order {
+		if j := m.jobs[id]; j.FinishedAt != nil {
+			finished++
+		}
+	}
+	kept := m.order[:0]
+	for _, id := range m.order {
+		if j := m.jobs[id]; j.FinishedAt != nil && finished > maxFinishedJobs {
+			delete(m.jobs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	m.order = kept
+}
+
+func (m *jobManager) get(id string) (job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+func (m *jobManager) list() []job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]job, 0, len(m.order))
+	for i := len(m.order) - 1; i >= 0; i-- {
+		jobs = append(jobs, *m.jobs[m.order[i]])
+	}
+	return jobs
+}
+
+func (s *Server) handleListJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"jobs": s.jobs.list()})
+}
+
+func (s *Server) handleGetJob(c *gin.Context) {
+	j, ok := s.jobs.get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, j)
+}
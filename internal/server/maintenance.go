@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fileSizes reports the size of the database file and its WAL, if any.
+func (s *Server) fileSizes() gin.H {
+	sizes := gin.H{"db": int64(0), "wal": int64(0)}
+	if info, err := os.Stat(s.path); err == nil {
+		sizes["db"] = info.Size()
+	}
+	if info, err := os.Stat(s.path + "-wal"); err == nil {
+		sizes["wal"] = info.Size()
+	}
+	return sizes
+}
+
+func (s *Server) handleMaintenance(c *gin.Context) {
+	op := c.Param("op")
+	target := c.Query("target")
+	if target != "" && !IsSafeIdentifier(target) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid target name"})
+		return
+	}
+	if target != "" && (op == "vacuum" || op == "optimize" || op == "checkpoint") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s does not accept a target", op)})
+		return
+	}
+
+	var stmts []string
+	switch op {
+	case "vacuum":
+		stmts = []string{"VACUUM"}
+	case "analyze", "reindex":
+		keyword := "ANALYZE"
+		if op == "reindex" {
+			keyword = "REINDEX"
+		}
+		if target != "" {
+			stmts = []string{fmt.Sprintf("%s %s", keyword, QuoteIdentifier(target))}
+			break
+		}
+		// One statement per table lets the job report progress
+		tables, err := s.userTables()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, table := range tables {
+			stmts = append(stmts, fmt.Sprintf("%s %s", keyword, QuoteIdentifier(table)))
+		}
+	case "optimize":
+		stmts = []string{"PRAGMA optimize"}
+	case "checkpoint":
+		stmts = []string{"PRAGMA wal_checkpoint(TRUNCATE)"}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported maintenance operation"})
+		return
+	}
+
+	j := s.jobs.start(op, target, func(j *job) (interface{}, error) {
+		return s.runMaintenance(j, stmts)
+	})
+	c.JSON(http.StatusAccepted, j)
+}
+
+func (s *Server) runMaintenance(j *job, stmts []string) (interface{}, error) {
+	result := gin.H{"statements": stmts, "sizeBefore": s.fileSizes()}
+	for i, stmt := range stmts {
+		j.progress(i, len(stmts), stmt)
+		if stmt == "PRAGMA wal_checkpoint(TRUNCATE)" {
+			var busy, logFrames, checkpointed int
+			if err := s.db.QueryRow(stmt).Scan(&busy, &logFrames, &checkpointed); err != nil {
+				return result, err
+			}
+			result["checkpoint"] = gin.H{"busy": busy != 0, "logFrames": logFrames, "checkpointedFrames": checkpointed}
+			continue
+		}
+		if _, err := s.db.Exec(stmt); err != nil {
+			return result, fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	j.progress(len(stmts), len(stmts), "done")
+	result["sizeAfter"] = s.fileSizes()
+	return result, nil
+}
@@ -0,0 +1,525 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// maxRollbackCopies is how many rollback copies of replaced databases are
+// kept next to the live file.
+const maxRollbackCopies = 3
+
+// holdDB keeps the connection pool in place for the duration of a request.
+// A restore takes the write side to drain in-flight requests before it
+// swaps the database file.
+func (s *Server) holdDB(c *gin.Context) {
+	s.dbGate.RLock()
+	defer s.dbGate.RUnlock()
+	c.Next()
+}
+
+func (s *Server) handleRestore(c *gin.Context) {
+	// Parse the form up front so an oversized body is refused before any
+	// field is read
+	if s.opts.MaxRestoreSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.opts.MaxRestoreSize)
+	}
+	var tooLarge *http.MaxBytesError
+	if err := c.Request.ParseMultipartForm(32 << 20); errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("upload exceeds %d bytes", tooLarge.Limit)})
+		return
+	}
+	mode := c.DefaultPostForm("mode", "replace")
+	if mode != "replace" && mode != "tables" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be replace or tables"})
+		return
+	}
+	var tables []string
+	if mode == "tables" {
+		var ok bool
+		if tables, ok = parseTableList(c, c.PostForm("tables")); !ok {
+			return
+		}
+		if len(tables) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no tables selected"})
+			return
+		}
+	}
+	upload, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing file upload"})
+		return
+	}
+
+	// Stage next to the live file so the final rename stays on one filesystem
+	staged, err := os.CreateTemp(filepath.Dir(s.path), ".sqliteviewer-restore-*.db")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stagedPath := staged.Name()
+	staged.Close()
+	defer os.Remove(stagedPath)
+	// Opening a WAL mode upload to validate it leaves -wal and -shm files
+	defer removeSidecars(stagedPath)
+
+	format, err := stageUpload(upload, stagedPath)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateDatabaseFile(stagedPath); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("uploaded %s failed validation: %v", format, err)})
+		return
+	}
+	// CreateTemp files are private; the restored file keeps the access
+	// other users and processes had to the one it replaces
+	if info, err := os.Stat(s.path); err == nil {
+		if err := os.Chmod(stagedPath, info.Mode().Perm()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	rollback := fmt.Sprintf("%s.rollback-%s", s.path, time.Now().Format(snapshotTimeLayout+".000000"))
+	if mode == "tables" {
+		s.dbGate.RLock()
+		defer s.dbGate.RUnlock()
+		if err := s.vacuumInto(rollback); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("write rollback copy: %v", err)})
+			return
+		}
+		if err := s.restoreTables(c.Request.Context(), stagedPath, tables); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "rollback": rollback})
+			return
+		}
+	} else if err := s.swapDatabase(stagedPath, rollback); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.pruneRollbacks(); err != nil {
+		log.Printf("prune rollback copies: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "ok",
+		"mode":     mode,
+		"format":   format,
+		"tables":   tables,
+		"rollback": rollback,
+	})
+}
+
+// stageUpload writes the upload to dest as a SQLite database. SQLite files
+// are copied as is; anything else is treated as an SQL dump and executed
+// against a fresh database.
+func stageUpload(upload *multipart.FileHeader, dest string) (string, error) {
+	src, err := upload.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	header := make([]byte, len(sqliteHeader))
+	n, err := io.ReadFull(src, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	header = header[:n]
+	rest := io.MultiReader(bytes.NewReader(header), src)
+
+	if bytes.Equal(header, sqliteHeader) {
+		out, err := os.OpenFile(dest, os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(out, rest); err != nil {
+			out.Close()
+			return "", err
+		}
+		return "sqlite", out.Close()
+	}
+
+	db, err := sql.Open("sqlite", dest)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	// A dump wraps its statements in BEGIN and COMMIT, so they must all
+	// run on one connection
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	count := 0
+	err = splitSQLStatements(rest, func(stmt string) error {
+		count++
+		if _, err := conn.ExecContext(context.Background(), stmt); err != nil {
+			return fmt.Errorf("execute SQL dump: statement %d: %w", count, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "sql", nil
+}
+
+// splitSQLStatements reads a script and calls fn with each complete
+// statement, so a large dump is never held in memory. Semicolons in
+// literals, identifiers and comments, and those inside trigger bodies, do
+// not end a statement.
+func splitSQLStatements(r io.Reader, fn func(stmt string) error) error {
+	br := bufio.NewReader(r)
+	var stmt strings.Builder
+	var quote rune     // closing quote of the literal or identifier we are in
+	var comment string // "--" or "/*" while in a comment
+	var prev rune
+	flush := func() error {
+		text := stmt.String()
+		stmt.Reset()
+		if len(tokenizeSQL(text)) == 0 {
+			return nil
+		}
+		return fn(text)
+	}
+	for {
+		c, _, err := br.ReadRune()
+		if errors.Is(err, io.EOF) {
+			return flush()
+		}
+		if err != nil {
+			return err
+		}
+		stmt.WriteRune(c)
+		switch {
+		case comment == "--":
+			if c == '\n' {
+				comment = ""
+			}
+		case comment == "/*":
+			if prev == '*' && c == '/' {
+				comment = ""
+				c = 0
+			}
+		case quote != 0:
+			if c == quote {
+				// A doubled quote reopens the literal on the next rune
+				quote = 0
+			}
+		case c == '-' && prev == '-':
+			comment = "--"
+		case c == '*' && prev == '/':
+			comment = "/*"
+			c = 0
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == ';':
+			if !triggerIncomplete(stmt.String()) {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		prev = c
+	}
+}
+
+// triggerIncomplete reports whether stmt is a CREATE TRIGGER whose body
+// has not reached its final END yet. CASE expressions in the body have
+// their own END.
+func triggerIncomplete(stmt string) bool {
+	tokens := tokenizeSQL(stmt)
+	i := 0
+	word := func(w string) bool {
+		return i < len(tokens) && tokens[i].kind == tokenWord && strings.EqualFold(tokens[i].text, w)
+	}
+	if !word("CREATE") {
+		return false
+	}
+	i++
+	if word("TEMP") || word("TEMPORARY") {
+		i++
+	}
+	if !word("TRIGGER") {
+		return false
+	}
+	body, cases := false, 0
+	for ; i < len(tokens); i++ {
+		switch {
+		case word("BEGIN"):
+			body = true
+		case !body:
+		case word("CASE"):
+			cases++
+		case word("END"):
+			if cases == 0 {
+				return false
+			}
+			cases--
+		}
+	}
+	return true
+}
+
+// removeSidecars deletes the journal files SQLite keeps next to path.
+func removeSidecars(path string) {
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		os.Remove(path + suffix)
+	}
+}
+
+// validateDatabaseFile opens path and requires a clean integrity check.
+func validateDatabaseFile(path string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// swapDatabase replaces the live file with staged, keeping the current file
+// at rollback. It drains all requests and jobs first and restores the old
+// file if the new one cannot be opened.
+func (s *Server) swapDatabase(staged, rollback string) error {
+	s.jobs.run.Lock()
+	defer s.jobs.run.Unlock()
+	s.dbGate.Lock()
+	defer s.dbGate.Unlock()
+
+	// Fold the WAL into the main file so the rollback copy is complete
+	if _, err := s.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	// Temp objects of the old file go with its session connection
+	s.session.Close()
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("close database: %w", err)
+	}
+	reopen := func() error {
+		db, err := sql.Open("sqlite", dataSourceName(s.path, s.opts))
+		if err != nil {
+			return err
+		}
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return err
+		}
+		session, err := openSession(db)
+		if err != nil {
+			db.Close()
+			return err
+		}
+		s.db, s.session = db, session
+		return nil
+	}
+
+	if err := os.Rename(s.path, rollback); err != nil {
+		if reopenErr := reopen(); reopenErr != nil {
+			return fmt.Errorf("keep rollback copy: %v (reopen: %v)", err, reopenErr)
+		}
+		return fmt.Errorf("keep rollback copy: %w", err)
+	}
+	removeSidecars(s.path)
+	if err := os.Rename(staged, s.path); err != nil {
+		os.Rename(rollback, s.path)
+		if reopenErr := reopen(); reopenErr != nil {
+			return fmt.Errorf("move restored file: %v (reopen: %v)", err, reopenErr)
+		}
+		return fmt.Errorf("move restored file: %w", err)
+	}
+	if err := reopen(); err != nil {
+		os.Remove(s.path)
+		os.Rename(rollback, s.path)
+		if reopenErr := reopen(); reopenErr != nil {
+			return fmt.Errorf("open restored file: %v (reopen: %v)", err, reopenErr)
+		}
+		return fmt.Errorf("open restored file: %w", err)
+	}
+
+	// Recorded changes refer to rows of the old file, and cached keys to
+	// its schema, whose version the new file may share
+	s.changes = newChangeLog()
+	s.incoming = &incomingKeyCache{}
+	return nil
+}
+
+// restoreTables replaces the given tables, with their indexes and
+// triggers, by the copies in the staged database in one transaction.
+func (s *Server) restoreTables(ctx context.Context, staged string, tables []string) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS restore_src", staged); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE restore_src")
+
+	// Dropping a parent table must not cascade into the others
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	if s.opts.ForeignKeys {
+		defer conn.ExecContext(context.Background(), "PRAGMA foreign_keys = ON")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		rows, err := tx.QueryContext(ctx, `SELECT type, sql FROM restore_src.sqlite_schema WHERE tbl_name = ? AND sql IS NOT NULL ORDER BY type = 'table' DESC, type = 'index' DESC`, table)
+		if err != nil {
+			return err
+		}
+		var stmts []string
+		for rows.Next() {
+			var typ, stmt string
+			if err := rows.Scan(&typ, &stmt); err != nil {
+				rows.Close()
+				return err
+			}
+			stmts = append(stmts, stmt)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		if len(stmts) == 0 {
+			return fmt.Errorf("table %s not found in upload", table)
+		}
+
+		quoted := QuoteIdentifier(table)
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS main.%s", quoted)); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, stmts[0]); err != nil {
+			return fmt.Errorf("create %s: %w", table, err)
+		}
+		copySQL, err := restoreCopySQL(ctx, tx, table)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, copySQL); err != nil {
+			return fmt.Errorf("copy %s: %w", table, err)
+		}
+		for _, stmt := range stmts[1:] {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("recreate objects of %s: %w", table, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// restoreCopySQL builds the statement copying table from the attached
+// upload. The rowid is copied along with the columns so tables without an
+// INTEGER PRIMARY KEY keep their row numbers; generated columns are left
+// to be computed.
+func restoreCopySQL(ctx context.Context, tx *sql.Tx, table string) (string, error) {
+	var withoutRowid bool
+	err := tx.QueryRowContext(ctx, `SELECT wr FROM pragma_table_list WHERE schema = 'restore_src' AND name = ?`, table).Scan(&withoutRowid)
+	if err != nil {
+		return "", err
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT name FROM pragma_table_xinfo(?, 'restore_src') WHERE hidden = 0 ORDER BY cid`, table)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var cols []string
+	if !withoutRowid {
+		cols = append(cols, "rowid")
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", err
+		}
+		cols = append(cols, QuoteIdentifier(name))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	list := strings.Join(cols, ", ")
+	quoted := QuoteIdentifier(table)
+	return fmt.Sprintf("INSERT INTO main.%s (%s) SELECT %s FROM restore_src.%s", quoted, list, list, quoted), nil
+}
+
+// pruneRollbacks removes the oldest rollback copies of the live file
+// beyond maxRollbackCopies.
+func (s *Server) pruneRollbacks() error {
+	dir, base := filepath.Split(s.path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	prefix := base + ".rollback-"
+	var copies []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) {
+			copies = append(copies, entry.Name())
+		}
+	}
+	// Names embed a sortable timestamp
+	sort.Sort(sort.Reverse(sort.StringSlice(copies)))
+	for i := maxRollbackCopies; i < len(copies); i++ {
+		if err := os.Remove(filepath.Join(dir, copies[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
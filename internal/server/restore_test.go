@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSplitSQLStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{
+			name:   "plain",
+			script: "CREATE TABLE t(a);\nINSERT INTO t VALUES (1);",
+			want:   []string{"CREATE TABLE t(a);", "\nINSERT INTO t VALUES (1);"},
+		},
+		{
+			name:   "semicolons in literals and comments",
+			script: "INSERT INTO t VALUES ('a;''b'); -- c;d\nSELECT \"x;y\" /* ; */ FROM [t;];",
+			want:   []string{"INSERT INTO t VALUES ('a;''b');", " -- c;d\nSELECT \"x;y\" /* ; */ FROM [t;];"},
+		},
+		{
+			name:   "trigger body",
+			script: "CREATE TRIGGER tr AFTER INSERT ON t BEGIN UPDATE t SET a = CASE WHEN a > 0 THEN 1 END; DELETE FROM u; END;SELECT 1;",
+			want:   []string{"CREATE TRIGGER tr AFTER INSERT ON t BEGIN UPDATE t SET a = CASE WHEN a > 0 THEN 1 END; DELETE FROM u; END;", "SELECT 1;"},
+		},
+		{
+			name:   "temp trigger",
+			script: "create temp trigger tr after delete on t begin select 1; end;",
+			want:   []string{"create temp trigger tr after delete on t begin select 1; end;"},
+		},
+		{
+			name:   "missing final semicolon and trailing comment",
+			script: "SELECT 1;\nSELECT 2\n-- done\n",
+			want:   []string{"SELECT 1;", "\nSELECT 2\n-- done\n"},
+		},
+		{
+			name:   "only comments",
+			script: "-- nothing;\n/* here; */\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := splitSQLStatements(strings.NewReader(tt.script), func(stmt string) error {
+				got = append(got, stmt)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestServer opens a server on a fresh database set up by schema.
+func newTestServer(t *testing.T, schema string, opts Options) *Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := New(path, http.Dir(t.TempDir()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.session.Close()
+		s.db.Close()
+	})
+	return s
+}
+
+// restoreRequest posts body as the restore upload with the given fields.
+func restoreRequest(t *testing.T, s *Server, body []byte, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	fw, err := mw.CreateFormFile("file", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(body)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/restore", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// databaseBytes returns the file of a database set up by schema, in WAL
+// mode when wal is set.
+func databaseBytes(t *testing.T, schema string, wal bool) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "upload.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wal {
+		if _, err := db.Exec("PRAGMA journal_mode = WAL"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRestore(t *testing.T) {
+	const current = "CREATE TABLE t(a); INSERT INTO t VALUES ('old'); CREATE TABLE u(b); INSERT INTO u VALUES ('keep');"
+	tests := []struct {
+		name       string
+		body       func(t *testing.T) []byte
+		fields     map[string]string
+		maxSize    int64
+		wantStatus int
+		// wantRows maps a query to its expected result, one string per row
+		wantRows map[string][]string
+	}{
+		{
+			name: "database file in WAL mode",
+			body: func(t *testing.T) []byte {
+				return databaseBytes(t, "CREATE TABLE t(a); INSERT INTO t VALUES ('new');", true)
+			},
+			wantStatus: http.StatusOK,
+			wantRows:   map[string][]string{"SELECT a FROM t": {"new"}},
+		},
+		{
+			name: "SQL dump with trigger",
+			body: func(t *testing.T) []byte {
+				return []byte(`BEGIN TRANSACTION;
+CREATE TABLE t(a);
+CREATE TABLE log(x);
+CREATE TRIGGER tr AFTER INSERT ON t BEGIN INSERT INTO log VALUES (new.a || ';'); END;
+INSERT INTO t VALUES ('a;b');
+COMMIT;`)
+			},
+			wantStatus: http.StatusOK,
+			wantRows: map[string][]string{
+				"SELECT a FROM t":   {"a;b"},
+				"SELECT x FROM log": {"a;b;"},
+			},
+		},
+		{
+			name: "tables mode keeps rowids and other tables",
+			body: func(t *testing.T) []byte {
+				return databaseBytes(t, "CREATE TABLE t(a); INSERT INTO t(rowid, a) VALUES (7, 'seven');", false)
+			},
+			fields:     map[string]string{"mode": "tables", "tables": "t"},
+			wantStatus: http.StatusOK,
+			wantRows: map[string][]string{
+				"SELECT rowid || a FROM t": {"7seven"},
+				"SELECT b FROM u":          {"keep"},
+			},
+		},
+		{
+			name:       "broken dump",
+			body:       func(t *testing.T) []byte { return []byte("CREATE TABLE t(a);\nINSERT INTO nowhere VALUES (1);") },
+			wantStatus: http.StatusBadRequest,
+			wantRows:   map[string][]string{"SELECT a FROM t": {"old"}},
+		},
+		{
+			name:       "upload too large",
+			body:       func(t *testing.T) []byte { return bytes.Repeat([]byte("-- padding\n"), 1000) },
+			maxSize:    1000,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantRows:   map[string][]string{"SELECT a FROM t": {"old"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, current, Options{MaxRestoreSize: tt.maxSize})
+			rec := restoreRequest(t, s, tt.body(t), tt.fields)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			for query, want := range tt.wantRows {
+				rows, err := s.db.Query(query)
+				if err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+				var got []string
+				for rows.Next() {
+					var v string
+					rows.Scan(&v)
+					got = append(got, v)
+				}
+				rows.Close()
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %q, want %q", query, got, want)
+				}
+			}
+
+			// Only the live file, its rollback copy and journals may remain
+			entries, err := os.ReadDir(filepath.Dir(s.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if strings.HasPrefix(e.Name(), ".sqliteviewer-restore-") {
+					t.Errorf("staged file %s left behind", e.Name())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// rowVersionField is the key carrying a row's version token in table data
+// responses and in PATCH/DELETE payloads.
+const rowVersionField = "_version"
+
+// anyRowVersion is the If-Match value that accepts whatever version the
+// row currently has.
+const anyRowVersion = "*"
+
+var errRowNotFound = errors.New("row not found")
+
+// rowVersion hashes the values of a row in column order, as scanned from
+// the database. Any change to a value, including a change of storage
+// class such as BLOB to TEXT with the same bytes, yields a different token.
+func rowVersion(columns []string, values []interface{}) string {
+	h := sha256.New()
+	for i, col := range columns {
+		fmt.Fprintf(h, "%s\x00%T\x00%v\x00", col, values[i], values[i])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// requestedRowVersion returns the token sent by the client, preferring the
+// If-Match header over the body field.
+func requestedRowVersion(c *gin.Context, payload map[string]interface{}) string {
+	if header := strings.TrimSpace(c.GetHeader("If-Match")); header != "" {
+		header = strings.TrimPrefix(header, "W/")
+		return strings.Trim(header, `"`)
+	}
+	if v, ok := payload[rowVersionField].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// readOptionalJSON decodes a JSON object body if the request has one.
+func readOptionalJSON(c *gin.Context) (map[string]interface{}, error) {
+	payload := map[string]interface{}{}
+	if c.Request.Body == nil || c.Request.ContentLength == 0 {
+		return payload, nil
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return payload, nil
+}
+
+type rowQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// rowSnapshot holds a row exactly as scanned, so it can be written back
+// without losing storage classes such as BLOB.
+type rowSnapshot struct {
+	columns []string
+	values  []interface{}
+	version string
+}
+
+// row returns the snapshot in the shape handleGetTableData returns rows.
+func (r *rowSnapshot) row() map[string]interface{} {
+	row := map[string]interface{}{}
+	for i, col := range r.columns {
+		row[col] = normalizeValue(r.values[i])
+	}
+	row[rowVersionField] = r.version
+	return row
+}
+
+// value returns the scanned value of col.
+func (r *rowSnapshot) value(col string) (interface{}, bool) {
+	for i, c := range r.columns {
+		if c == col {
+			return r.values[i], true
+		}
+	}
+	return nil, false
+}
+
+func loadRowSnapshot(q rowQuerier, table string, rowid int64) (*rowSnapshot, error) {
+	rows, err := q.Query(fmt.Sprintf("SELECT rowid as _rowid, * FROM %s WHERE rowid = ?", QuoteIdentifier(table)), rowid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errRowNotFound
+	}
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	return &rowSnapshot{
+		columns: columns,
+		values:  values,
+		version: rowVersion(columns, values),
+	}, nil
+}
+
+// loadRow reads a single row by rowid in the same shape handleGetTableData
+// returns it, including its version token.
+func loadRow(q rowQuerier, table string, rowid int64) (map[string]interface{}, error) {
+	snap, err := loadRowSnapshot(q, table, rowid)
+	if err != nil {
+		return nil, err
+	}
+	return snap.row(), nil
+}
+
+// checkRowVersion verifies inside tx that the row still matches the version
+// the client last saw and returns it. On mismatch it responds with 409 and
+// the current row.
+func checkRowVersion(c *gin.Context, tx *sql.Tx, table string, rowid int64, version string) (*rowSnapshot, bool) {
+	if version == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "missing row version: send If-Match header or _version field"})
+		return nil, false
+	}
+	current, err := loadRowSnapshot(tx, table, rowid)
+	if errors.Is(err, errRowNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "row not found"})
+		return nil, false
+	}
+	if err != nil {
+		writeFailed(c, http.StatusBadRequest, err)
+		return nil, false
+	}
+	if version != anyRowVersion && current.version != version {
+		c.JSON(http.StatusConflict, gin.H{"error": "row has been modified", "row": current.row()})
+		return nil, false
+	}
+	return current, true
+}
+
+// writeFailed responds to a failed row write with status, unless another
+// connection held the database lock past the busy timeout. Retrying may
+// then succeed, so it responds with 503 and Retry-After instead.
+func writeFailed(c *gin.Context, status int, err error) {
+	var se *sqlite.Error
+	if errors.As(err, &se) {
+		if code := se.Code() & 0xff; code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED {
+			c.Header("Retry-After", "1")
+			status = http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRowVersionChecks(t *testing.T) {
+	const schema = "CREATE TABLE t(a); INSERT INTO t VALUES ('x');"
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		// ifMatch is sent as If-Match; "current" stands for the row's
+		// version
+		ifMatch string
+		// lock holds the write lock from another connection
+		lock       bool
+		wantStatus int
+		wantA      string
+	}{
+		{name: "update with version", method: http.MethodPatch, body: `{"a": "y"}`, ifMatch: "current", wantStatus: http.StatusOK, wantA: "y"},
+		{name: "update with quoted weak version", method: http.MethodPatch, body: `{"a": "y"}`, ifMatch: `W/"current"`, wantStatus: http.StatusOK, wantA: "y"},
+		{name: "update with stale version", method: http.MethodPatch, body: `{"a": "y"}`, ifMatch: "0123456789abcdef", wantStatus: http.StatusConflict, wantA: "x"},
+		{name: "update without version", method: http.MethodPatch, body: `{"a": "y"}`, wantStatus: http.StatusPreconditionRequired, wantA: "x"},
+		{name: "update with any version", method: http.MethodPatch, body: `{"a": "y"}`, ifMatch: "*", wantStatus: http.StatusOK, wantA: "y"},
+		{name: "version in body", method: http.MethodPatch, body: `{"a": "y", "_version": "current"}`, wantStatus: http.StatusOK, wantA: "y"},
+		{name: "delete with any version", method: http.MethodDelete, ifMatch: "*", wantStatus: http.StatusOK},
+		{name: "delete with stale version", method: http.MethodDelete, ifMatch: "0123456789abcdef", wantStatus: http.StatusConflict, wantA: "x"},
+		{name: "database locked", method: http.MethodPatch, body: `{"a": "y"}`, ifMatch: "*", lock: true, wantStatus: http.StatusServiceUnavailable, wantA: "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, schema, Options{})
+			snap, err := loadRowSnapshot(s.db, "t", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.lock {
+				other, err := sql.Open("sqlite", s.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer other.Close()
+				conn, err := other.Conn(t.Context())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				if _, err := conn.ExecContext(t.Context(), "BEGIN IMMEDIATE"); err != nil {
+					t.Fatal(err)
+				}
+				defer conn.ExecContext(t.Context(), "ROLLBACK")
+			}
+
+			req := httptest.NewRequest(tt.method, "/api/tables/t/rows/1", strings.NewReader(strings.ReplaceAll(tt.body, "current", snap.version)))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", strings.ReplaceAll(tt.ifMatch, "current", snap.version))
+			}
+			rec := httptest.NewRecorder()
+			s.router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.lock && rec.Header().Get("Retry-After") == "" {
+				t.Error("Retry-After missing")
+			}
+			var a string
+			err = s.db.QueryRow("SELECT a FROM t WHERE rowid = 1").Scan(&a)
+			if err == sql.ErrNoRows {
+				a = ""
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if a != tt.wantA {
+				t.Errorf("a = %q, want %q", a, tt.wantA)
+			}
+		})
+	}
+}
@@ -0,0 +1,573 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tableDefinition describes a table to create. Columns may declare
+// single-column constraints themselves; composite keys go in PrimaryKey,
+// Unique and ForeignKeys.
+type tableDefinition struct {
+	Name         string                 `json:"name"`
+	Columns      []columnDefinition     `json:"columns"`
+	PrimaryKey   []string               `json:"primaryKey"`
+	Unique       [][]string             `json:"unique"`
+	Checks       []string               `json:"checks"`
+	ForeignKeys  []foreignKeyDefinition `json:"foreignKeys"`
+	Strict       bool                   `json:"strict"`
+	WithoutRowid bool                   `json:"withoutRowid"`
+	IfNotExists  bool                   `json:"ifNotExists"`
+}
+
+type columnDefinition struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// PrimaryKey makes this column the primary key; AutoIncrement needs it
+	// and an INTEGER type.
+	PrimaryKey    bool   `json:"primaryKey,omitempty"`
+	AutoIncrement bool   `json:"autoIncrement,omitempty"`
+	NotNull       bool   `json:"notNull,omitempty"`
+	Unique        bool   `json:"unique,omitempty"`
+	Collate       string `json:"collate,omitempty"`
+	// Default is a JSON value written as an SQL literal, where null means
+	// no default; DefaultExpr is an expression such as CURRENT_TIMESTAMP
+	// and wins if both are set.
+	Default     json.RawMessage `json:"default,omitempty"`
+	DefaultExpr string          `json:"defaultExpr,omitempty"`
+	Check       string          `json:"check,omitempty"`
+	// Generated makes the column GENERATED ALWAYS AS this expression.
+	Generated string `json:"generated,omitempty"`
+	Stored    bool   `json:"stored,omitempty"`
+}
+
+type foreignKeyDefinition struct {
+	Columns    []string `json:"columns"`
+	Table      string   `json:"table"`
+	References []string `json:"references"`
+	OnDelete   string   `json:"onDelete"`
+	OnUpdate   string   `json:"onUpdate"`
+	Deferrable bool     `json:"deferrable"`
+}
+
+// columnTypePattern accepts type names such as TEXT, UNSIGNED BIG INT or
+// DECIMAL(10, 2).
+var columnTypePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_ ]*(\(\s*[+-]?\d+\s*(,\s*[+-]?\d+\s*)?\))?$`)
+
+var foreignKeyActions = map[string]bool{
+	"NO ACTION": true, "RESTRICT": true, "SET NULL": true, "SET DEFAULT": true, "CASCADE": true,
+}
+
+// tableDefinitionSQL validates def and generates its CREATE TABLE statement.
+func tableDefinitionSQL(def tableDefinition) (string, error) {
+	if !IsSafeIdentifier(def.Name) {
+		return "", fmt.Errorf("invalid table name: %s", def.Name)
+	}
+	if strings.HasPrefix(strings.ToLower(def.Name), "sqlite_") {
+		return "", errors.New("table names starting with sqlite_ are reserved")
+	}
+	if len(def.Columns) == 0 {
+		return "", errors.New("a table needs at least one column")
+	}
+
+	columns := map[string]bool{}
+	var defs []string
+	primaryKeys := 0
+	for _, col := range def.Columns {
+		key := strings.ToLower(col.Name)
+		if columns[key] {
+			return "", fmt.Errorf("duplicate column: %s", col.Name)
+		}
+		columns[key] = true
+		if col.PrimaryKey {
+			primaryKeys++
+		}
+		stmt, err := columnDefinitionSQL(col)
+		if err != nil {
+			return "", err
+		}
+		defs = append(defs, stmt)
+	}
+	if primaryKeys > 1 || (primaryKeys > 0 && len(def.PrimaryKey) > 0) {
+		return "", errors.New("declare a composite primary key with primaryKey, not on several columns")
+	}
+	if def.WithoutRowid && primaryKeys == 0 && len(def.PrimaryKey) == 0 {
+		return "", errors.New("a WITHOUT ROWID table needs a primary key")
+	}
+
+	checkColumns := func(what string, names []string) (string, error) {
+		if len(names) == 0 {
+			return "", fmt.Errorf("%s needs at least one column", what)
+		}
+		quoted := make([]string, len(names))
+		for i, name := range names {
+			if !columns[strings.ToLower(name)] {
+				return "", fmt.Errorf("%s names unknown column: %s", what, name)
+			}
+			quoted[i] = QuoteIdentifier(name)
+		}
+		return strings.Join(quoted, ", "), nil
+	}
+	if len(def.PrimaryKey) > 0 {
+		list, err := checkColumns("primary key", def.PrimaryKey)
+		if err != nil {
+			return "", err
+		}
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", list))
+	}
+	for _, unique := range def.Unique {
+		list, err := checkColumns("unique constraint", unique)
+		if err != nil {
+			return "", err
+		}
+		defs = append(defs, fmt.Sprintf("UNIQUE (%s)", list))
+	}
+	for _, check := range def.Checks {
+		if err := checkSQLExpression(check); err != nil {
+			return "", fmt.Errorf("check constraint: %w", err)
+		}
+		defs = append(defs, fmt.Sprintf("CHECK (%s)", check))
+	}
+	for _, fk := range def.ForeignKeys {
+		list, err := checkColumns("foreign key", fk.Columns)
+		if err != nil {
+			return "", err
+		}
+		stmt, err := foreignKeyClause(fk, len(fk.Columns))
+		if err != nil {
+			return "", err
+		}
+		defs = append(defs, fmt.Sprintf("FOREIGN KEY (%s) %s", list, stmt))
+	}
+
+	var b strings.Builder
+	b.WriteString("CREATE TABLE ")
+	if def.IfNotExists {
+		b.WriteString("IF NOT EXISTS ")
+	}
+	b.WriteString(QuoteIdentifier(def.Name))
+	b.WriteString(" (\n  ")
+	b.WriteString(strings.Join(defs, ",\n  "))
+	b.WriteString("\n)")
+	var options []string
+	if def.WithoutRowid {
+		options = append(options, "WITHOUT ROWID")
+	}
+	if def.Strict {
+		options = append(options, "STRICT")
+	}
+	if len(options) > 0 {
+		b.WriteString(" " + strings.Join(options, ", "))
+	}
+	return b.String(), nil
+}
+
+// columnDefinitionSQL generates the definition of one column.
+func columnDefinitionSQL(col columnDefinition) (string, error) {
+	if !IsSafeIdentifier(col.Name) {
+		return "", fmt.Errorf("invalid column name: %s", col.Name)
+	}
+	parts := []string{QuoteIdentifier(col.Name)}
+	if col.Type != "" {
+		if !columnTypePattern.MatchString(col.Type) {
+			return "", fmt.Errorf("invalid type for column %s: %s", col.Name, col.Type)
+		}
+		parts = append(parts, col.Type)
+	}
+	if col.PrimaryKey {
+		parts = append(parts, "PRIMARY KEY")
+		if col.AutoIncrement {
+			if !strings.EqualFold(col.Type, "INTEGER") {
+				return "", fmt.Errorf("column %s: AUTOINCREMENT needs type INTEGER", col.Name)
+			}
+			parts = append(parts, "AUTOINCREMENT")
+		}
+	} else if col.AutoIncrement {
+		return "", fmt.Errorf("column %s: AUTOINCREMENT needs a primary key", col.Name)
+	}
+	if col.NotNull {
+		parts = append(parts, "NOT NULL")
+	}
+	if col.Unique {
+		parts = append(parts, "UNIQUE")
+	}
+	if col.Check != "" {
+		if err := checkSQLExpression(col.Check); err != nil {
+			return "", fmt.Errorf("column %s check: %w", col.Name, err)
+		}
+		parts = append(parts, fmt.Sprintf("CHECK (%s)", col.Check))
+	}
+	switch {
+	case col.DefaultExpr != "":
+		if err := checkSQLExpression(col.DefaultExpr); err != nil {
+			return "", fmt.Errorf("column %s default: %w", col.Name, err)
+		}
+		parts = append(parts, fmt.Sprintf("DEFAULT (%s)", col.DefaultExpr))
+	case col.hasDefault():
+		literal, err := defaultLiteral(col.Default)
+		if err != nil {
+			return "", fmt.Errorf("column %s default: %w", col.Name, err)
+		}
+		parts = append(parts, "DEFAULT "+literal)
+	}
+	if col.Collate != "" {
+		if !IsSafeIdentifier(col.Collate) {
+			return "", fmt.Errorf("column %s: invalid collation %s", col.Name, col.Collate)
+		}
+		parts = append(parts, "COLLATE "+col.Collate)
+	}
+	if col.Generated != "" {
+		if err := checkSQLExpression(col.Generated); err != nil {
+			return "", fmt.Errorf("column %s generated: %w", col.Name, err)
+		}
+		kind := "VIRTUAL"
+		if col.Stored {
+			kind = "STORED"
+		}
+		parts = append(parts, fmt.Sprintf("GENERATED ALWAYS AS (%s) %s", col.Generated, kind))
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func (col columnDefinition) hasDefault() bool {
+	return len(col.Default) > 0 && strings.TrimSpace(string(col.Default)) != "null"
+}
+
+// foreignKeyClause generates the REFERENCES clause of fk, which has n
+// child columns.
+func foreignKeyClause(fk foreignKeyDefinition, n int) (string, error) {
+	if !IsSafeIdentifier(fk.Table) {
+		return "", fmt.Errorf("invalid referenced table: %s", fk.Table)
+	}
+	clause := "REFERENCES " + QuoteIdentifier(fk.Table)
+	// Without referenced columns the parent's primary key is used
+	if len(fk.References) > 0 {
+		if len(fk.References) != n {
+			return "", fmt.Errorf("foreign key to %s has %d columns but references %d", fk.Table, n, len(fk.References))
+		}
+		quoted := make([]string, len(fk.References))
+		for i, name := range fk.References {
+			if !IsSafeIdentifier(name) {
+				return "", fmt.Errorf("invalid referenced column: %s", name)
+			}
+			quoted[i] = QuoteIdentifier(name)
+		}
+		clause += " (" + strings.Join(quoted, ", ") + ")"
+	}
+	for _, action := range []struct{ on, value string }{{"DELETE", fk.OnDelete}, {"UPDATE", fk.OnUpdate}} {
+		if action.value == "" {
+			continue
+		}
+		value := strings.ToUpper(strings.TrimSpace(action.value))
+		if !foreignKeyActions[value] {
+			return "", fmt.Errorf("invalid ON %s action: %s", action.on, action.value)
+		}
+		clause += fmt.Sprintf(" ON %s %s", action.on, value)
+	}
+	if fk.Deferrable {
+		clause += " DEFERRABLE INITIALLY DEFERRED"
+	}
+	return clause, nil
+}
+
+// defaultLiteral turns a JSON default into an SQL literal.
+func defaultLiteral(raw json.RawMessage) (string, error) {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", errors.New("invalid JSON value")
+	}
+	switch v := v.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case json.Number:
+		return v.String(), nil
+	case string:
+		return formatSQLValue(v), nil
+	default:
+		return "", errors.New("must be a string, number, boolean or null")
+	}
+}
+
+// checkSQLExpression rejects expression text that could end the enclosing
+// parentheses or statement early. It does not parse the expression;
+// SQLite reports any other mistake when the statement runs.
+func checkSQLExpression(expr string) error {
+	if strings.TrimSpace(expr) == "" {
+		return errors.New("expression is empty")
+	}
+	depth := 0
+	var quote rune
+	for _, r := range expr {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '[':
+			quote = ']'
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth < 0 {
+				return errors.New("unbalanced parentheses")
+			}
+		case r == ';':
+			return errors.New("expression cannot contain ;")
+		}
+	}
+	if quote != 0 {
+		return errors.New("unterminated quote")
+	}
+	if depth != 0 {
+		return errors.New("unbalanced parentheses")
+	}
+	if strings.Contains(expr, "--") || strings.Contains(expr, "/*") {
+		return errors.New("expression cannot contain comments")
+	}
+	return nil
+}
+
+// handleCreateTable creates a table from a tableDefinition and returns the
+// generated SQL. With dryRun it only returns the SQL.
+func (s *Server) handleCreateTable(c *gin.Context) {
+	var req struct {
+		tableDefinition
+		DryRun bool `json:"dryRun"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+		return
+	}
+	stmt, err := tableDefinitionSQL(req.tableDefinition)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"sql": stmt, "dryRun": true})
+		return
+	}
+
+	if !req.IfNotExists {
+		var exists int
+		if err := s.db.QueryRow(`SELECT count(*) FROM sqlite_schema WHERE name = ? COLLATE NOCASE`, req.Name).Scan(&exists); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if exists > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s already exists", req.Name), "sql": stmt})
+			return
+		}
+	}
+	if _, err := s.db.Exec(stmt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "sql": stmt})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"sql": stmt, "table": req.Name})
+}
+
+// tableState is a table's definition as far as SQLite reports it through
+// pragmas. CHECK constraints, collations, generated column expressions and
+// DEFERRABLE clauses are not reported, so Def lacks them.
+type tableState struct {
+	Def       tableDefinition
+	SQL       string
+	Generated map[string]bool
+	// Indexed holds the columns used by any index, lower-cased.
+	Indexed map[string]bool
+}
+
+var autoIncrementPattern = regexp.MustCompile(`(?i)\bAUTOINCREMENT\b`)
+
+// loadTableState reads the definition of table back from the database.
+func (s *Server) loadTableState(table string) (*tableState, error) {
+	st := &tableState{Def: tableDefinition{Name: table}, Generated: map[string]bool{}, Indexed: map[string]bool{}}
+	if err := s.db.QueryRow(`SELECT sql FROM sqlite_schema WHERE type = 'table' AND name = ?`, table).Scan(&st.SQL); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("table %s not found", table)
+		}
+		return nil, err
+	}
+	var strict, withoutRowid int
+	if err := s.db.QueryRow(`SELECT wr, strict FROM pragma_table_list(?) WHERE schema = 'main'`, table).Scan(&withoutRowid, &strict); err != nil {
+		return nil, err
+	}
+	st.Def.Strict, st.Def.WithoutRowid = strict != 0, withoutRowid != 0
+
+	rows, err := s.db.Query(`SELECT name, type, "notnull", dflt_value, pk, hidden FROM pragma_table_xinfo(?) ORDER BY cid`, table)
+	if err != nil {
+		return nil, err
+	}
+	// pk is the column's position in the primary key
+	keyColumns := map[int]string{}
+	for rows.Next() {
+		var col columnDefinition
+		var notNull, pk, hidden int
+		var dflt sql.NullString
+		if err := rows.Scan(&col.Name, &col.Type, &notNull, &dflt, &pk, &hidden); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		// hidden is 1 for virtual table columns, 2 and 3 for generated ones
+		if hidden == 1 {
+			continue
+		}
+		if hidden > 1 {
+			st.Generated[strings.ToLower(col.Name)] = true
+		}
+		col.NotNull = notNull != 0
+		col.DefaultExpr = dflt.String
+		if pk > 0 {
+			keyColumns[pk] = col.Name
+		}
+		st.Def.Columns = append(st.Def.Columns, col)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var primaryKey []string
+	for i := 1; i <= len(keyColumns); i++ {
+		primaryKey = append(primaryKey, keyColumns[i])
+	}
+	if len(primaryKey) == 1 {
+		col := st.column(primaryKey[0])
+		col.PrimaryKey = true
+		col.AutoIncrement = autoIncrementPattern.MatchString(st.SQL)
+	} else {
+		st.Def.PrimaryKey = primaryKey
+	}
+
+	unique, err := uniqueConstraints(s.db, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, cols := range unique {
+		if len(cols) == 1 {
+			st.column(cols[0]).Unique = true
+		} else {
+			st.Def.Unique = append(st.Def.Unique, cols)
+		}
+	}
+
+	indexes, err := s.db.Query(`SELECT name FROM pragma_index_list(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for indexes.Next() {
+		var name string
+		if err := indexes.Scan(&name); err != nil {
+			indexes.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	indexes.Close()
+	if err := indexes.Err(); err != nil {
+		return nil, err
+	}
+	for _, index := range names {
+		cols, err := indexColumns(s.db, index)
+		if err != nil {
+			return nil, err
+		}
+		for _, col := range cols {
+			st.Indexed[strings.ToLower(col)] = true
+		}
+	}
+
+	keys, err := s.foreignKeys(table)
+	if err != nil {
+		return nil, err
+	}
+	for _, fk := range keys {
+		def := foreignKeyDefinition{Columns: fk.From, Table: fk.Table, References: fk.To}
+		if fk.OnDelete != "NO ACTION" {
+			def.OnDelete = fk.OnDelete
+		}
+		if fk.OnUpdate != "NO ACTION" {
+			def.OnUpdate = fk.OnUpdate
+		}
+		st.Def.ForeignKeys = append(st.Def.ForeignKeys, def)
+	}
+	return st, nil
+}
+
+// column returns the column called name, ignoring case, or nil.
+func (st *tableState) column(name string) *columnDefinition {
+	for i := range st.Def.Columns {
+		if strings.EqualFold(st.Def.Columns[i].Name, name) {
+			return &st.Def.Columns[i]
+		}
+	}
+	return nil
+}
+
+// uniqueConstraints lists the columns of each UNIQUE constraint of table,
+// leaving out unique indexes created with CREATE INDEX.
+func uniqueConstraints(q rowQuerier, table string) ([][]string, error) {
+	rows, err := q.Query(`SELECT name FROM pragma_index_list(?) WHERE origin = 'u' ORDER BY seq DESC`, table)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		indexes = append(indexes, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var unique [][]string
+	for _, index := range indexes {
+		cols, err := indexColumns(q, index)
+		if err != nil {
+			return nil, err
+		}
+		unique = append(unique, cols)
+	}
+	return unique, nil
+}
+
+// indexColumns lists the key columns of an index in order. Expressions
+// are left out since SQLite does not report their text.
+func indexColumns(q rowQuerier, index string) ([]string, error) {
+	rows, err := q.Query(`SELECT name FROM pragma_index_info(?) WHERE name IS NOT NULL ORDER BY seqno`, index)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols = append(cols, name)
+	}
+	return cols, rows.Err()
+}
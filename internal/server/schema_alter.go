@@ -0,0 +1,861 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// columnChange is a column of the desired table. From names the existing
+// column it continues, so a different Name renames it; without From a
+// column keeps the existing column of the same name or is new.
+type columnChange struct {
+	columnDefinition
+	From string `json:"from"`
+}
+
+// schemaChange is a resolved request to change a table.
+type schemaChange struct {
+	current *tableState
+	want    tableDefinition
+	// sources holds the existing column behind each wanted column, or ""
+	// for new ones.
+	sources []string
+	dropped []string
+	// renames maps lower-cased existing names to new ones.
+	renames map[string]string
+	// keepChecks is set when the request leaves out checks, so the
+	// table's CHECK constraints stay as they are.
+	keepChecks bool
+}
+
+// alterPlan is the SQL that carries out a schemaChange.
+type alterPlan struct {
+	// Strategy is "alter" when ALTER TABLE suffices and "rebuild" when the
+	// table is copied into a new one.
+	Strategy   string   `json:"strategy"`
+	Statements []string `json:"statements"`
+	SQL        string   `json:"sql"`
+	Warnings   []string `json:"warnings,omitempty"`
+	DryRun     bool     `json:"dryRun"`
+}
+
+var deferrablePattern = regexp.MustCompile(`(?i)\bDEFERRABLE\b`)
+
+// newSchemaChange resolves a request body against the current table.
+// Table-level fields missing from the body keep their current value.
+func newSchemaChange(st *tableState, body []byte) (*schemaChange, error) {
+	var req struct {
+		Columns []columnChange `json:"columns"`
+	}
+	var present map[string]json.RawMessage
+	var given tableDefinition
+	if json.Unmarshal(body, &req) != nil || json.Unmarshal(body, &present) != nil || json.Unmarshal(body, &given) != nil {
+		return nil, errors.New("invalid JSON payload")
+	}
+	if len(req.Columns) == 0 {
+		return nil, errors.New("columns lists the columns the table should have")
+	}
+
+	ch := &schemaChange{current: st, sources: make([]string, len(req.Columns)), renames: map[string]string{}}
+	used := map[string]bool{}
+	claim := func(i int, name string) error {
+		col := st.column(name)
+		if col == nil {
+			return fmt.Errorf("no such column: %s", name)
+		}
+		key := strings.ToLower(col.Name)
+		if used[key] {
+			return fmt.Errorf("column %s is used twice", col.Name)
+		}
+		used[key] = true
+		ch.sources[i] = col.Name
+		return nil
+	}
+	// Explicit sources first, so a new column may take the name of one
+	// that is renamed
+	for i, col := range req.Columns {
+		if col.From != "" {
+			if err := claim(i, col.From); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i, col := range req.Columns {
+		if col.From == "" && st.column(col.Name) != nil && !used[strings.ToLower(col.Name)] {
+			if err := claim(i, col.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, col := range st.Def.Columns {
+		if !used[strings.ToLower(col.Name)] {
+			ch.dropped = append(ch.dropped, col.Name)
+		}
+	}
+
+	ch.want = tableDefinition{Name: st.Def.Name}
+	for i, col := range req.Columns {
+		ch.want.Columns = append(ch.want.Columns, col.columnDefinition)
+		if src := ch.sources[i]; src != "" && src != col.Name {
+			ch.renames[strings.ToLower(src)] = col.Name
+		}
+	}
+	cur := st.Def
+	if _, ok := present["primaryKey"]; ok {
+		ch.want.PrimaryKey = given.PrimaryKey
+	} else {
+		ch.want.PrimaryKey = ch.renamedList(cur.PrimaryKey)
+	}
+	if _, ok := present["unique"]; ok {
+		ch.want.Unique = given.Unique
+	} else {
+		for _, cols := range cur.Unique {
+			ch.want.Unique = append(ch.want.Unique, ch.renamedList(cols))
+		}
+	}
+	ch.want.Checks = given.Checks
+	_, hasChecks := present["checks"]
+	ch.keepChecks = !hasChecks
+	if _, ok := present["foreignKeys"]; ok {
+		ch.want.ForeignKeys = given.ForeignKeys
+	} else {
+		for _, fk := range cur.ForeignKeys {
+			fk.Columns = ch.renamedList(fk.Columns)
+			// A key on the table itself follows its renamed columns too
+			if strings.EqualFold(fk.Table, cur.Name) {
+				fk.References = ch.renamedList(fk.References)
+			}
+			ch.want.ForeignKeys = append(ch.want.ForeignKeys, fk)
+		}
+	}
+	ch.want.Strict, ch.want.WithoutRowid = cur.Strict, cur.WithoutRowid
+	if _, ok := present["strict"]; ok {
+		ch.want.Strict = given.Strict
+	}
+	if _, ok := present["withoutRowid"]; ok {
+		ch.want.WithoutRowid = given.WithoutRowid
+	}
+
+	// Validates names, types and constraints
+	if _, err := tableDefinitionSQL(ch.want); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// needsRebuild tells whether ALTER TABLE cannot make the change: anything
+// beyond renaming, dropping unconstrained columns and appending columns
+// ADD COLUMN accepts.
+func (ch *schemaChange) needsRebuild() bool {
+	cur, want := ch.current.Def, ch.want
+	sameList := func(a, b []string) bool {
+		return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
+	}
+	if !sameList(want.PrimaryKey, ch.renamedList(cur.PrimaryKey)) || len(want.Checks) > 0 ||
+		want.Strict != cur.Strict || want.WithoutRowid != cur.WithoutRowid {
+		return true
+	}
+	if len(want.Unique) != len(cur.Unique) || len(want.ForeignKeys) != len(cur.ForeignKeys) {
+		return true
+	}
+	for i, cols := range cur.Unique {
+		if !sameList(want.Unique[i], ch.renamedList(cols)) {
+			return true
+		}
+	}
+	for i, fk := range cur.ForeignKeys {
+		w := want.ForeignKeys[i]
+		if !sameList(w.Columns, ch.renamedList(fk.Columns)) || !strings.EqualFold(w.Table, fk.Table) ||
+			!sameList(w.References, fk.References) && !(strings.EqualFold(fk.Table, cur.Name) && sameList(w.References, ch.renamedList(fk.References))) ||
+			!strings.EqualFold(w.OnDelete, fk.OnDelete) || !strings.EqualFold(w.OnUpdate, fk.OnUpdate) || w.Deferrable {
+			return true
+		}
+	}
+
+	// Kept columns must stay in order and unchanged, with new ones after
+	// them
+	next, added := 0, false
+	for i, col := range want.Columns {
+		src := ch.sources[i]
+		if src == "" {
+			added = true
+			if !addableColumn(col) {
+				return true
+			}
+			continue
+		}
+		if added {
+			return true
+		}
+		for next < len(cur.Columns) && containsFold(ch.dropped, cur.Columns[next].Name) {
+			next++
+		}
+		if next == len(cur.Columns) || cur.Columns[next].Name != src {
+			return true
+		}
+		if !sameColumn(cur.Columns[next], col) {
+			return true
+		}
+		next++
+	}
+
+	for _, name := range ch.dropped {
+		if !ch.droppableColumn(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ch *schemaChange) renamedList(names []string) []string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = name
+		if to, ok := ch.renames[strings.ToLower(name)]; ok {
+			out[i] = to
+		}
+	}
+	return out
+}
+
+// droppableColumn follows the restrictions of ALTER TABLE DROP COLUMN.
+func (ch *schemaChange) droppableColumn(name string) bool {
+	st := ch.current
+	col := st.column(name)
+	if col.PrimaryKey || col.Unique || st.Indexed[strings.ToLower(name)] {
+		return false
+	}
+	for _, pk := range st.Def.PrimaryKey {
+		if strings.EqualFold(pk, name) {
+			return false
+		}
+	}
+	for _, fk := range st.Def.ForeignKeys {
+		for _, from := range fk.Columns {
+			if strings.EqualFold(from, name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// addableColumn follows the restrictions of ALTER TABLE ADD COLUMN.
+func addableColumn(col columnDefinition) bool {
+	if col.PrimaryKey || col.Unique || col.DefaultExpr != "" || (col.Generated != "" && col.Stored) {
+		return false
+	}
+	if col.NotNull && col.Generated == "" {
+		literal, err := defaultLiteral(col.Default)
+		return col.hasDefault() && err == nil && literal != "NULL"
+	}
+	return true
+}
+
+// sameColumn compares a column as SQLite reports it with a wanted one.
+// Clauses SQLite does not report count as changes.
+func sameColumn(cur, want columnDefinition) bool {
+	if !strings.EqualFold(cur.Type, want.Type) || cur.NotNull != want.NotNull || cur.PrimaryKey != want.PrimaryKey ||
+		cur.AutoIncrement != want.AutoIncrement || cur.Unique != want.Unique {
+		return false
+	}
+	if want.Check != "" || want.Collate != "" || want.Generated != "" {
+		return false
+	}
+	dflt := want.DefaultExpr
+	if dflt == "" && want.hasDefault() {
+		dflt, _ = defaultLiteral(want.Default)
+	}
+	return strings.TrimSpace(dflt) == strings.TrimSpace(cur.DefaultExpr)
+}
+
+// hasRowidAlias tells whether def has an INTEGER PRIMARY KEY, which holds
+// the rowid itself.
+func (def tableDefinition) hasRowidAlias() bool {
+	if def.WithoutRowid {
+		return false
+	}
+	for _, col := range def.Columns {
+		if (col.PrimaryKey || len(def.PrimaryKey) == 1 && strings.EqualFold(def.PrimaryKey[0], col.Name)) && strings.EqualFold(col.Type, "INTEGER") {
+			return true
+		}
+	}
+	return false
+}
+
+// alterTable carries out ch. Renames use ALTER TABLE RENAME COLUMN, which
+// also rewrites indexes, triggers and views. The rest uses DROP and ADD
+// COLUMN when they can make the change, and otherwise the rebuild from
+// https://www.sqlite.org/lang_altertable.html#otheralter: create the new
+// table, copy the rows, drop the old table, rename the new one and
+// recreate the indexes, triggers and views. All of it runs in one
+// transaction. A dry run plans the same statements and rolls back.
+func (s *Server) alterTable(ctx context.Context, ch *schemaChange, dryRun bool) (*alterPlan, error) {
+	table := ch.current.Def.Name
+	rebuild := ch.needsRebuild()
+	plan := &alterPlan{Strategy: "alter", Statements: []string{}, DryRun: dryRun}
+	if rebuild {
+		plan.Strategy = "rebuild"
+		if deferrablePattern.MatchString(ch.current.SQL) {
+			plan.Warnings = append(plan.Warnings, "the table has DEFERRABLE foreign keys; the rebuilt table only has those given in the request")
+		}
+		for i, src := range ch.sources {
+			if src != "" && ch.current.Generated[strings.ToLower(src)] && ch.want.Columns[i].Generated == "" && !ch.keepsClauses(i) {
+				return nil, fmt.Errorf("column %s is generated; give its expression in generated", ch.want.Columns[i].Name)
+			}
+		}
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var foreignKeysOn bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeysOn); err != nil {
+		return nil, err
+	}
+	// foreign_keys cannot change inside a transaction, and dropping the old
+	// table must not cascade
+	if rebuild && foreignKeysOn && !dryRun {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return nil, err
+		}
+		defer conn.ExecContext(context.Background(), "PRAGMA foreign_keys = ON")
+	}
+
+	// The rebuild may break references of this table and of the tables
+	// referencing it. Violations that already exist do not block it.
+	checked := []string{table}
+	if rebuild {
+		incoming, err := s.incomingForeignKeys(table)
+		if err != nil {
+			return nil, err
+		}
+		for _, fk := range incoming {
+			if !strings.EqualFold(fk.Table, table) {
+				checked = append(checked, fk.Table)
+			}
+		}
+	}
+	violationsBefore := 0
+	if rebuild && !dryRun {
+		if violationsBefore, err = foreignKeyViolations(ctx, conn, checked); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// Renames run even in a dry run, since the rebuild recreates indexes,
+	// triggers and views from the SQL they leave behind
+	run := func(stmt string, always bool) error {
+		plan.Statements = append(plan.Statements, stmt)
+		if dryRun && !always {
+			return nil
+		}
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+		return nil
+	}
+
+	dropped := append([]string(nil), ch.dropped...)
+	if !rebuild {
+		for _, name := range dropped {
+			if err := run(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", QuoteIdentifier(table), QuoteIdentifier(name)), false); err != nil {
+				return nil, err
+			}
+		}
+		dropped = nil
+	}
+	if err := s.renameColumns(ch, dropped, run); err != nil {
+		return nil, err
+	}
+
+	if !rebuild {
+		for i, col := range ch.want.Columns {
+			if ch.sources[i] != "" {
+				continue
+			}
+			def, err := columnDefinitionSQL(col)
+			if err != nil {
+				return nil, err
+			}
+			if err := run(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", QuoteIdentifier(table), def), false); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		stmts, warnings, err := rebuildStatements(ctx, tx, ch)
+		if err != nil {
+			return nil, err
+		}
+		plan.Warnings = append(plan.Warnings, warnings...)
+		for _, stmt := range stmts {
+			if err := run(stmt, false); err != nil {
+				return nil, err
+			}
+		}
+		if !dryRun {
+			after, err := foreignKeyViolations(ctx, tx, checked)
+			if err != nil {
+				return nil, err
+			}
+			if after > violationsBefore {
+				return nil, fmt.Errorf("the change would leave %d rows violating foreign keys", after-violationsBefore)
+			}
+		}
+	}
+
+	plan.SQL = alterScript(plan.Statements, rebuild && foreignKeysOn, checked)
+	if dryRun {
+		return plan, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// renameColumns renames columns in place. A column whose new name is still
+// taken by another goes through a temporary name first, as do columns of
+// dropped that are in the way.
+func (s *Server) renameColumns(ch *schemaChange, dropped []string, run func(string, bool) error) error {
+	table := QuoteIdentifier(ch.current.Def.Name)
+	taken := map[string]bool{}
+	for _, col := range ch.current.Def.Columns {
+		taken[strings.ToLower(col.Name)] = true
+	}
+	for _, name := range ch.dropped {
+		if !containsFold(dropped, name) {
+			delete(taken, strings.ToLower(name))
+		}
+	}
+	wanted := map[string]bool{}
+	for _, col := range ch.want.Columns {
+		wanted[strings.ToLower(col.Name)] = true
+	}
+
+	temp := 0
+	tempName := func() string {
+		temp++
+		return fmt.Sprintf("_sqliteviewer_tmp_%d", temp)
+	}
+	type rename struct{ from, to string }
+	var later []rename
+	for _, name := range dropped {
+		if wanted[strings.ToLower(name)] {
+			if err := run(fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, QuoteIdentifier(name), QuoteIdentifier(tempName())), true); err != nil {
+				return err
+			}
+			delete(taken, strings.ToLower(name))
+		}
+	}
+	for i, col := range ch.want.Columns {
+		src := ch.sources[i]
+		if src == "" || src == col.Name {
+			continue
+		}
+		if taken[strings.ToLower(col.Name)] {
+			tmp := tempName()
+			if err := run(fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, QuoteIdentifier(src), QuoteIdentifier(tmp)), true); err != nil {
+				return err
+			}
+			later = append(later, rename{tmp, col.Name})
+			continue
+		}
+		if err := run(fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, QuoteIdentifier(src), QuoteIdentifier(col.Name)), true); err != nil {
+			return err
+		}
+		delete(taken, strings.ToLower(src))
+		taken[strings.ToLower(col.Name)] = true
+	}
+	for _, r := range later {
+		if err := run(fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, QuoteIdentifier(r.from), QuoteIdentifier(r.to)), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keepsClauses tells whether the i-th wanted column continues an existing
+// column unchanged, so the clauses pragmas do not report carry over.
+func (ch *schemaChange) keepsClauses(i int) bool {
+	want := ch.want.Columns[i]
+	if ch.sources[i] == "" || want.Check != "" || want.Collate != "" || want.Generated != "" {
+		return false
+	}
+	return sameColumn(*ch.current.column(ch.sources[i]), want)
+}
+
+// rebuildStatements plans the rebuild once the columns carry their new
+// names. COLLATE, CHECK and GENERATED clauses of unchanged columns, and
+// CHECK constraints the request does not replace, are taken from the
+// table's SQL; the warnings name the clauses that are lost.
+func rebuildStatements(ctx context.Context, tx *sql.Tx, ch *schemaChange) ([]string, []string, error) {
+	table := ch.current.Def.Name
+	newName := table + "_new"
+	for i := 2; ; i++ {
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM sqlite_schema WHERE name = ? COLLATE NOCASE`, newName).Scan(&n); err != nil {
+			return nil, nil, err
+		}
+		if n == 0 {
+			break
+		}
+		newName = fmt.Sprintf("%s_new%d", table, i)
+	}
+	// Renames have already rewritten the table's SQL, so clauses taken
+	// from it use the new column names
+	var tableSQL string
+	if err := tx.QueryRowContext(ctx, `SELECT sql FROM sqlite_schema WHERE type = 'table' AND name = ?`, table).Scan(&tableSQL); err != nil {
+		return nil, nil, err
+	}
+	columnSQL, constraints, _ := splitTableSQL(tableSQL)
+
+	var warnings []string
+	def := ch.want
+	def.Name = newName
+	def.Columns = append([]columnDefinition(nil), ch.want.Columns...)
+	for i := range def.Columns {
+		col := &def.Columns[i]
+		clauses := parseColumnClauses(columnSQL[strings.ToLower(col.Name)])
+		switch {
+		case ch.keepsClauses(i):
+			col.Collate, col.Check = clauses.collate, strings.Join(clauses.checks, " AND ")
+			col.Generated, col.Stored = clauses.generated, clauses.stored
+		case ch.sources[i] != "" && (clauses.collate != "" && col.Collate == "" || len(clauses.checks) > 0 && col.Check == ""):
+			warnings = append(warnings, fmt.Sprintf("column %s changes, so its CHECK and COLLATE clauses are only those given in the request", col.Name))
+		}
+	}
+	if ch.keepChecks {
+		for _, c := range constraints {
+			expr, ok := checkConstraintExpr(c)
+			if !ok {
+				continue
+			}
+			if mentionsAny(expr, ch.dropped) {
+				warnings = append(warnings, fmt.Sprintf("constraint %s uses a dropped column and is removed", c))
+				continue
+			}
+			def.Checks = append(def.Checks, expr)
+		}
+	}
+	create, err := tableDefinitionSQL(def)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Views and triggers that mention the table would fail while it is
+	// missing, so they are dropped and created again afterwards
+	rows, err := tx.QueryContext(ctx, `SELECT type, name, tbl_name, sql FROM sqlite_schema WHERE type IN ('index', 'trigger', 'view') AND sql IS NOT NULL ORDER BY rowid`)
+	if err != nil {
+		return nil, nil, err
+	}
+	type object struct{ typ, name, table, sql string }
+	var objects []object
+	for rows.Next() {
+		var o object
+		if err := rows.Scan(&o.typ, &o.name, &o.table, &o.sql); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		objects = append(objects, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	namePattern := regexp.MustCompile(`(?i)(^|[^\w$])` + regexp.QuoteMeta(table) + `([^\w$]|$)`)
+	mentions := namePattern.MatchString
+	views := map[string]bool{}
+	var recreate []object
+	var stmts []string
+	for _, o := range objects {
+		if o.typ == "view" && mentions(o.sql) {
+			views[strings.ToLower(o.name)] = true
+		}
+	}
+	for _, o := range objects {
+		switch {
+		case o.typ == "index" && strings.EqualFold(o.table, table):
+		case o.typ == "view" && views[strings.ToLower(o.name)]:
+		case o.typ == "trigger" && (strings.EqualFold(o.table, table) || views[strings.ToLower(o.table)] || mentions(o.sql)):
+			stmts = append(stmts, "DROP TRIGGER "+QuoteIdentifier(o.name))
+		default:
+			continue
+		}
+		recreate = append(recreate, o)
+	}
+	for _, o := range recreate {
+		if o.typ == "view" {
+			stmts = append(stmts, "DROP VIEW "+QuoteIdentifier(o.name))
+		}
+	}
+
+	var into, from []string
+	if !ch.current.Def.WithoutRowid && !ch.want.WithoutRowid && !ch.want.hasRowidAlias() {
+		// Keep rowids, which identify rows in the browser and undo history
+		into, from = append(into, "rowid"), append(from, "rowid")
+	}
+	for i, col := range def.Columns {
+		if ch.sources[i] == "" || col.Generated != "" {
+			continue
+		}
+		into = append(into, QuoteIdentifier(col.Name))
+		from = append(from, QuoteIdentifier(col.Name))
+	}
+	stmts = append(stmts, create)
+	if len(into) > 0 {
+		stmts = append(stmts, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s",
+			QuoteIdentifier(newName), strings.Join(into, ", "), strings.Join(from, ", "), QuoteIdentifier(table)))
+	}
+	stmts = append(stmts,
+		"DROP TABLE "+QuoteIdentifier(table),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", QuoteIdentifier(newName), QuoteIdentifier(table)))
+	for _, o := range recreate {
+		stmts = append(stmts, o.sql)
+	}
+	return stmts, warnings, nil
+}
+
+// columnClauses are the clauses of a column definition that pragmas do
+// not report.
+type columnClauses struct {
+	collate   string
+	checks    []string
+	generated string
+	stored    bool
+}
+
+// parseColumnClauses reads COLLATE, CHECK and GENERATED clauses from a
+// column definition as written in CREATE TABLE.
+func parseColumnClauses(def string) columnClauses {
+	var cl columnClauses
+	tokens := sqlClauseTokens(def)
+	// The first token is the column name
+	for i := 1; i < len(tokens); i++ {
+		next := func() string {
+			if i+1 < len(tokens) {
+				i++
+				return tokens[i]
+			}
+			return ""
+		}
+		switch strings.ToUpper(tokens[i]) {
+		case "COLLATE":
+			cl.collate = unquoteSQL(next())
+		case "CHECK":
+			if expr := next(); strings.HasPrefix(expr, "(") {
+				cl.checks = append(cl.checks, strings.TrimSpace(expr[1:len(expr)-1]))
+			}
+		case "AS":
+			if expr := next(); strings.HasPrefix(expr, "(") {
+				cl.generated = strings.TrimSpace(expr[1 : len(expr)-1])
+				if i+1 < len(tokens) && strings.EqualFold(tokens[i+1], "STORED") {
+					cl.stored = true
+				}
+			}
+		}
+	}
+	return cl
+}
+
+// checkConstraintExpr returns the expression of a CHECK table constraint,
+// named or not.
+func checkConstraintExpr(constraint string) (string, bool) {
+	tokens := sqlClauseTokens(constraint)
+	if len(tokens) >= 2 && strings.EqualFold(tokens[0], "CONSTRAINT") {
+		tokens = tokens[2:]
+	}
+	if len(tokens) != 2 || !strings.EqualFold(tokens[0], "CHECK") || !strings.HasPrefix(tokens[1], "(") {
+		return "", false
+	}
+	return strings.TrimSpace(tokens[1][1 : len(tokens[1])-1]), true
+}
+
+// sqlClauseTokens splits SQL into words, quoted names and strings, and
+// whole parenthesized groups, which is enough to pick clauses out of a
+// definition.
+func sqlClauseTokens(s string) []string {
+	var tokens []string
+	skipQuoted := func(i int) int {
+		end := s[i]
+		if end == '[' {
+			end = ']'
+		}
+		for i++; i < len(s); i++ {
+			if s[i] == end {
+				if end != ']' && i+1 < len(s) && s[i+1] == end {
+					i++
+					continue
+				}
+				return i
+			}
+		}
+		return len(s) - 1
+	}
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '\'' || ch == '"' || ch == '`' || ch == '[':
+			j := skipQuoted(i)
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		case ch == '(':
+			depth, j := 0, i
+			for ; j < len(s); j++ {
+				switch s[j] {
+				case '\'', '"', '`', '[':
+					j = skipQuoted(j)
+				case '(':
+					depth++
+				case ')':
+					depth--
+				}
+				if depth == 0 {
+					break
+				}
+			}
+			if j == len(s) {
+				j--
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		default:
+			j := i + 1
+			for j < len(s) && !strings.ContainsRune(" \t\n\r'\"`[()", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// mentionsAny tells whether expr uses any of names as a word.
+func mentionsAny(expr string, names []string) bool {
+	for _, name := range names {
+		pattern := regexp.MustCompile(`(?i)(^|[^\w$])["\x60\[]?` + regexp.QuoteMeta(name) + `["\x60\]]?([^\w$]|$)`)
+		if pattern.MatchString(expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// foreignKeyViolations counts the rows of tables that violate a foreign key.
+func foreignKeyViolations(ctx context.Context, q interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}, tables []string) (int, error) {
+	total := 0
+	for _, table := range tables {
+		var n int
+		if err := q.QueryRowContext(ctx, `SELECT count(*) FROM pragma_foreign_key_check(?)`, table).Scan(&n); err != nil {
+			return 0, fmt.Errorf("check foreign keys of %s: %w", table, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// alterScript writes statements as a script that can be run by hand.
+func alterScript(statements []string, foreignKeysOff bool, checked []string) string {
+	var b strings.Builder
+	if foreignKeysOff {
+		b.WriteString("PRAGMA foreign_keys = OFF;\n")
+	}
+	b.WriteString("BEGIN;\n")
+	for _, stmt := range statements {
+		b.WriteString(stmt)
+		b.WriteString(";\n")
+	}
+	if foreignKeysOff {
+		for _, table := range checked {
+			fmt.Fprintf(&b, "PRAGMA foreign_key_check(%s);\n", QuoteIdentifier(table))
+		}
+	}
+	b.WriteString("COMMIT;\n")
+	if foreignKeysOff {
+		b.WriteString("PRAGMA foreign_keys = ON;\n")
+	}
+	return b.String()
+}
+
+func containsFold(list []string, name string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAlterTable changes a table to the column list and constraints in
+// the request. See newSchemaChange for the request and alterTable for how
+// the change is made.
+func (s *Server) handleAlterTable(c *gin.Context) {
+	table := c.Param("table")
+	if !IsSafeIdentifier(table) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid table name"})
+		return
+	}
+	rel, ok := s.findRelation(c, table)
+	if !ok {
+		return
+	}
+	switch {
+	case rel.Type == "virtual":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "virtual tables cannot be altered"})
+		return
+	case rel.Type == "shadow":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shadow tables belong to their virtual table and cannot be altered"})
+		return
+	case rel.Type != "table" || rel.Temp:
+		c.JSON(http.StatusNotFound, gin.H{"error": "table not found"})
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var opts struct {
+		DryRun bool `json:"dryRun"`
+	}
+	json.Unmarshal(body, &opts)
+
+	st, err := s.loadTableState(table)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ch, err := newSchemaChange(st, body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	plan, err := s.alterTable(c.Request.Context(), ch, opts.DryRun)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
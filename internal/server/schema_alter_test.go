@@ -0,0 +1,285 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const alterTestSchema = `
+CREATE TABLE t(id INTEGER PRIMARY KEY, a TEXT, b INTEGER, c TEXT);
+CREATE INDEX t_b ON t(b);
+INSERT INTO t VALUES (1, 'one', 1, 'x'), (2, 'two', 2, 'y');`
+
+// alterRequest builds a schema change body from the current columns of
+// table t, changed by edit, with extra table-level fields.
+func alterRequest(t *testing.T, s *Server, edit func([]columnChange) []columnChange, extra map[string]interface{}) *schemaChange {
+	t.Helper()
+	st, err := s.loadTableState("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cols []columnChange
+	for _, col := range st.Def.Columns {
+		cols = append(cols, columnChange{columnDefinition: col})
+	}
+	if edit != nil {
+		cols = edit(cols)
+	}
+	req := map[string]interface{}{"columns": cols}
+	for k, v := range extra {
+		req[k] = v
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := newSchemaChange(st, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+func TestNeedsRebuild(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func([]columnChange) []columnChange
+		extra map[string]interface{}
+		want  bool
+	}{
+		{name: "unchanged", want: false},
+		{
+			name: "rename",
+			edit: func(cols []columnChange) []columnChange {
+				cols[1].From, cols[1].Name = "a", "renamed"
+				return cols
+			},
+			want: false,
+		},
+		{
+			name: "drop unindexed column",
+			edit: func(cols []columnChange) []columnChange { return cols[:3] },
+			want: false,
+		},
+		{
+			name: "drop indexed column",
+			edit: func(cols []columnChange) []columnChange { return append(cols[:2], cols[3]) },
+			want: true,
+		},
+		{
+			name: "append nullable column",
+			edit: func(cols []columnChange) []columnChange {
+				return append(cols, columnChange{columnDefinition: columnDefinition{Name: "d", Type: "TEXT"}})
+			},
+			want: false,
+		},
+		{
+			name: "append NOT NULL column with default",
+			edit: func(cols []columnChange) []columnChange {
+				return append(cols, columnChange{columnDefinition: columnDefinition{Name: "d", Type: "INTEGER", NotNull: true, Default: json.RawMessage("0")}})
+			},
+			want: false,
+		},
+		{
+			name: "append NOT NULL column without default",
+			edit: func(cols []columnChange) []columnChange {
+				return append(cols, columnChange{columnDefinition: columnDefinition{Name: "d", Type: "INTEGER", NotNull: true}})
+			},
+			want: true,
+		},
+		{
+			name: "insert column in the middle",
+			edit: func(cols []columnChange) []columnChange {
+				return append([]columnChange{cols[0], {columnDefinition: columnDefinition{Name: "d", Type: "TEXT"}}}, cols[1:]...)
+			},
+			want: true,
+		},
+		{
+			name: "reorder",
+			edit: func(cols []columnChange) []columnChange {
+				cols[1], cols[2] = cols[2], cols[1]
+				return cols
+			},
+			want: true,
+		},
+		{
+			name: "change type",
+			edit: func(cols []columnChange) []columnChange {
+				cols[3].Type = "BLOB"
+				return cols
+			},
+			want: true,
+		},
+		{
+			name: "add column check",
+			edit: func(cols []columnChange) []columnChange {
+				cols[2].Check = "b > 0"
+				return cols
+			},
+			want: true,
+		},
+		{name: "make strict", extra: map[string]interface{}{"strict": true}, want: true},
+		{name: "add unique constraint", extra: map[string]interface{}{"unique": [][]string{{"a", "c"}}}, want: true},
+		{name: "add table check", extra: map[string]interface{}{"checks": []string{"a <> c"}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, alterTestSchema, Options{})
+			ch := alterRequest(t, s, tt.edit, tt.extra)
+			if got := ch.needsRebuild(); got != tt.want {
+				t.Errorf("needsRebuild() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameColumns(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func([]columnChange) []columnChange
+		// rebuild passes the dropped columns on, as a rebuild drops them
+		// later instead of first
+		rebuild bool
+		want    []string
+		// wantColumns are the columns of t afterwards
+		wantColumns []string
+	}{
+		{
+			name: "plain rename",
+			edit: func(cols []columnChange) []columnChange {
+				cols[1].From, cols[1].Name = "a", "x"
+				return cols
+			},
+			want:        []string{`ALTER TABLE "t" RENAME COLUMN "a" TO "x"`},
+			wantColumns: []string{"id", "x", "b", "c"},
+		},
+		{
+			name: "swap through temporary names",
+			edit: func(cols []columnChange) []columnChange {
+				cols[1].From, cols[1].Name = "a", "c"
+				cols[3].From, cols[3].Name = "c", "a"
+				return cols
+			},
+			want: []string{
+				`ALTER TABLE "t" RENAME COLUMN "a" TO "_sqliteviewer_tmp_1"`,
+				`ALTER TABLE "t" RENAME COLUMN "c" TO "_sqliteviewer_tmp_2"`,
+				`ALTER TABLE "t" RENAME COLUMN "_sqliteviewer_tmp_1" TO "c"`,
+				`ALTER TABLE "t" RENAME COLUMN "_sqliteviewer_tmp_2" TO "a"`,
+			},
+			wantColumns: []string{"id", "c", "b", "a"},
+		},
+		{
+			name: "take the name of a column already dropped",
+			edit: func(cols []columnChange) []columnChange {
+				cols[1].From, cols[1].Name = "a", "c"
+				return cols[:3]
+			},
+			want:        []string{`ALTER TABLE "t" RENAME COLUMN "a" TO "c"`},
+			wantColumns: []string{"id", "c", "b"},
+		},
+		{
+			name: "move a column still to be dropped out of the way",
+			edit: func(cols []columnChange) []columnChange {
+				cols[1].From, cols[1].Name = "a", "b"
+				return append(cols[:2], cols[3])
+			},
+			rebuild: true,
+			want: []string{
+				`ALTER TABLE "t" RENAME COLUMN "b" TO "_sqliteviewer_tmp_1"`,
+				`ALTER TABLE "t" RENAME COLUMN "a" TO "b"`,
+			},
+			wantColumns: []string{"id", "b", "_sqliteviewer_tmp_1", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, alterTestSchema, Options{})
+			ch := alterRequest(t, s, tt.edit, nil)
+			var dropped []string
+			if tt.rebuild {
+				dropped = ch.dropped
+			} else {
+				// ALTER TABLE drops columns before renaming
+				for _, name := range ch.dropped {
+					if _, err := s.db.Exec("ALTER TABLE t DROP COLUMN " + QuoteIdentifier(name)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			var got []string
+			err := s.renameColumns(ch, dropped, func(stmt string, always bool) error {
+				got = append(got, stmt)
+				_, err := s.db.Exec(stmt)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("statements\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			st, err := s.loadTableState("t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var columns []string
+			for _, col := range st.Def.Columns {
+				columns = append(columns, col.Name)
+			}
+			if !reflect.DeepEqual(columns, tt.wantColumns) {
+				t.Errorf("columns %q, want %q", columns, tt.wantColumns)
+			}
+		})
+	}
+}
+
+func TestSQLClauseTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{name: "words", sql: "a TEXT NOT NULL", want: []string{"a", "TEXT", "NOT", "NULL"}},
+		{name: "quoted names", sql: "\"my \"\"col\"\"\" [x y] `z`", want: []string{"\"my \"\"col\"\"\"", "[x y]", "`z`"}},
+		{name: "string with parenthesis", sql: "DEFAULT ')' COLLATE nocase", want: []string{"DEFAULT", "')'", "COLLATE", "nocase"}},
+		{
+			name: "nested groups",
+			sql:  "b INT CHECK (b > (1) AND c IN (')', \"(\")) AS (b * 2) STORED",
+			want: []string{"b", "INT", "CHECK", "(b > (1) AND c IN (')', \"(\"))", "AS", "(b * 2)", "STORED"},
+		},
+		{name: "unbalanced group", sql: "CHECK (a > 1", want: []string{"CHECK", "(a > 1"}},
+		{name: "unterminated string", sql: "DEFAULT 'x", want: []string{"DEFAULT", "'x"}},
+		{name: "empty", sql: " \n\t"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sqlClauseTokens(tt.sql); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseColumnClauses(t *testing.T) {
+	tests := []struct {
+		name string
+		def  string
+		want columnClauses
+	}{
+		{name: "none", def: "a TEXT NOT NULL DEFAULT 'x'"},
+		{name: "collate", def: `a TEXT COLLATE "NOCASE"`, want: columnClauses{collate: "NOCASE"}},
+		{name: "checks", def: "a INT CHECK (a > 0) CHECK(a < 10)", want: columnClauses{checks: []string{"a > 0", "a < 10"}}},
+		{name: "virtual", def: "a INT GENERATED ALWAYS AS (b + 1)", want: columnClauses{generated: "b + 1"}},
+		{name: "stored", def: "a INT AS ( b || 'x' ) STORED", want: columnClauses{generated: "b || 'x'", stored: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseColumnClauses(tt.def); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,82 +3,165 @@ package server
 import (
 	"bytes"
 	"database/sql"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	_ "modernc.org/sqlite"
 )
 
 type Server struct {
-	db     *sql.DB
-	router *gin.Engine
-	static http.FileSystem
+	db      *sql.DB
+	session sessionConn
+	dbGate  sync.RWMutex
+	path    string
+	opts    Options
+	router  *gin.Engine
+	static  http.FileSystem
+	changes *changeLog
+	jobs    *jobManager
+	queries *recentQueries
+	// incoming caches the foreign keys referencing each table
+	incoming *incomingKeyCache
 }
 
-func New(dbPath string, static http.FileSystem) (*Server, error) {
-	db, err := sql.Open("sqlite", dbPath)
+// Options tunes how the database connection is opened.
+type Options struct {
+	// ForeignKeys enables PRAGMA foreign_keys on every pooled connection.
+	ForeignKeys bool
+	// SnapshotDir receives database snapshots; empty disables them.
+	SnapshotDir string
+	// SnapshotInterval schedules automatic snapshots; zero takes them only
+	// on request.
+	SnapshotInterval time.Duration
+	// SnapshotKeep is how many snapshots to retain; zero keeps all.
+	SnapshotKeep int
+	// MaxRestoreSize caps the request body of a restore in bytes; zero
+	// accepts any size.
+	MaxRestoreSize int64
+}
+
+func New(dbPath string, static http.FileSystem, opts Options) (*Server, error) {
+	db, err := sql.Open("sqlite", dataSourceName(dbPath, opts))
 	if err != nil {
 		return nil, fmt.Errorf("open sqlite file: %w", err)
 	}
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("ping sqlite file: %w", err)
 	}
+	session, err := openSession(db)
+	if err != nil {
+		return nil, fmt.Errorf("open session connection: %w", err)
+	}
 
 	s := &Server{
-		db:     db,
-		router: gin.Default(),
-		static: static,
+		db:       db,
+		session:  session,
+		path:     dbPath,
+		opts:     opts,
+		router:   gin.Default(),
+		static:   static,
+		changes:  newChangeLog(),
+		jobs:     newJobManager(),
+		queries:  &recentQueries{},
+		incoming: &incomingKeyCache{},
 	}
 	s.registerRoutes()
 	return s, nil
 }
 
+// dataSourceName appends connection pragmas to dbPath. The driver runs
+// them on each new connection, so they hold for the whole pool.
+func dataSourceName(dbPath string, opts Options) string {
+	// Wait for locks held by maintenance jobs or other processes instead of
+	// failing immediately with SQLITE_BUSY.
+	pragmas := []string{"_pragma=busy_timeout(5000)"}
+	// Write transactions take the write lock at BEGIN, so a row read to
+	// check its version cannot change before the write. Exports pass
+	// ReadOnly to begin deferred and not block writers.
+	pragmas = append(pragmas, "_txlock=immediate")
+	if opts.ForeignKeys {
+		pragmas = append(pragmas, "_pragma=foreign_keys(1)")
+	}
+	return dbPath + "?" + strings.Join(pragmas, "&")
+}
+
 func (s *Server) Run(addr string) error {
+	if s.opts.SnapshotDir != "" && s.opts.SnapshotInterval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go s.runSnapshots(stop)
+	}
 	return s.router.Run(addr)
 }
 
 func (s *Server) registerRoutes() {
-	api := s.router.Group("/api")
+	// Restore swaps the connection pool, so it must not run inside holdDB
+	s.router.POST("/api/admin/restore", s.handleRestore)
+
+	api := s.router.Group("/api", s.holdDB)
 	{
 		api.GET("/tables", s.handleListTables)
+		api.POST("/tables", s.handleCreateTable)
 		api.GET("/tables/:table", s.handleGetTableData)
 		api.GET("/tables/:table/schema", s.handleGetTableSchema)
+		api.PATCH("/tables/:table/schema", s.handleAlterTable)
 		api.POST("/tables/:table/rows", s.handleInsertRow)
 		api.PATCH("/tables/:table/rows/:rowid", s.handleUpdateRow)
 		api.DELETE("/tables/:table/rows/:rowid", s.handleDeleteRow)
+		api.GET("/tables/:table/rows/:rowid/references", s.handleGetRowReferences)
 		api.GET("/tables/:table/export", s.handleExportTable)
+		api.POST("/tables/:table/import", s.handleImportTable)
+		api.GET("/export", s.handleExportDatabase)
+		api.GET("/export/formats", s.handleListExportFormats)
 		api.POST("/query", s.handleExecuteQuery)
+		api.POST("/query/export", s.handleExportQuery)
 		api.GET("/indexes", s.handleListIndexes)
+		api.POST("/indexes", s.handleCreateIndex)
+		api.POST("/indexes/suggest", s.handleSuggestIndexes)
+		api.GET("/indexes/:index", s.handleGetIndex)
+		api.DELETE("/indexes/:index", s.handleDropIndex)
+		api.GET("/triggers", s.handleListTriggers)
+		api.POST("/triggers", s.handleCreateTrigger)
+		api.DELETE("/triggers/:trigger", s.handleDropTrigger)
 		api.GET("/views", s.handleListViews)
+		api.POST("/diff", s.handleDiff)
+		api.GET("/check", s.handleCheckDatabase)
+		api.GET("/check/foreign-keys", s.handleCheckForeignKeys)
+		api.GET("/stats/space", s.handleSpaceStats)
+		api.GET("/jobs", s.handleListJobs)
+		api.GET("/jobs/:id", s.handleGetJob)
+		api.POST("/admin/maintenance/:op", s.handleMaintenance)
+		api.GET("/backup", s.handleBackup)
+		api.GET("/backup/snapshots", s.handleListSnapshots)
+		api.POST("/backup/snapshots", s.handleCreateSnapshot)
+		api.GET("/backup/snapshots/:name", s.handleDownloadSnapshot)
+		api.GET("/changes", s.handleListChanges)
+		api.POST("/changes/undo", s.handleUndoChange)
+		api.POST("/changes/redo", s.handleRedoChange)
 	}
 
 	s.router.NoRoute(s.handleSPA)
 }
 
 func (s *Server) handleListTables(c *gin.Context) {
-	rows, err := s.db.Query(`SELECT name FROM sqlite_schema WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	list, err := s.tableList()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
 
-	var tables []string
-	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		tables = append(tables, name)
+	// Shadow tables appear only under their virtual table in details
+	tables := make([]string, len(list))
+	for i, e := range list {
+		tables[i] = e.Name
 	}
-
-	c.JSON(http.StatusOK, gin.H{"tables": tables})
+	c.JSON(http.StatusOK, gin.H{"tables": tables, "details": list})
 }
 
 func (s *Server) handleGetTableData(c *gin.Context) {
@@ -89,9 +172,6 @@ func (s *Server) handleGetTableData(c *gin.Context) {
 	}
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
 	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
-	search := c.DefaultQuery("search", "")
-	orderBy := c.DefaultQuery("orderBy", "")
-	orderDir := c.DefaultQuery("orderDir", "ASC")
 
 	if limit <= 0 {
 		limit = 100
@@ -99,43 +179,20 @@ func (s *Server) handleGetTableData(c *gin.Context) {
 	if offset < 0 {
 		offset = 0
 	}
-	if orderDir != "ASC" && orderDir != "DESC" {
-		orderDir = "ASC"
-	}
 
-	// Build WHERE clause for search
-	whereClause := ""
-	args := []interface{}{}
-	if search != "" {
-		// Get all columns to search in
-		colRows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", QuoteIdentifier(table)))
-		if err == nil {
-			defer colRows.Close()
-			var searchConditions []string
-			for colRows.Next() {
-				var cid int
-				var colName, colType string
-				var notnull, pk int
-				var dflt sql.NullString
-				if err := colRows.Scan(&cid, &colName, &colType, &notnull, &dflt, &pk); err == nil {
-					searchConditions = append(searchConditions, fmt.Sprintf("%s LIKE ?", QuoteIdentifier(colName)))
-					args = append(args, "%"+search+"%")
-				}
-			}
-			if len(searchConditions) > 0 {
-				whereClause = "WHERE (" + strings.Join(searchConditions, " OR ") + ")"
-			}
-		}
+	rel, ok := s.findRelation(c, table)
+	if !ok {
+		return
 	}
 
-	// Build ORDER BY clause
-	orderClause := ""
-	if orderBy != "" && IsSafeIdentifier(orderBy) {
-		orderClause = fmt.Sprintf("ORDER BY %s %s", QuoteIdentifier(orderBy), orderDir)
-	}
+	q := s.querierFor(rel)
+	whereClause, orderClause, args := tableFilterClauses(q, table, parseTableFilter(c))
 
-	// Build query
+	// Build query. Views and WITHOUT ROWID tables have no rowid.
 	baseQuery := fmt.Sprintf("SELECT rowid as _rowid, * FROM %s", QuoteIdentifier(table))
+	if !rel.hasRowid() {
+		baseQuery = fmt.Sprintf("SELECT * FROM %s", QuoteIdentifier(table))
+	}
 	query := baseQuery
 	if whereClause != "" {
 		query += " " + whereClause
@@ -146,7 +203,7 @@ func (s *Server) handleGetTableData(c *gin.Context) {
 	query += " LIMIT ? OFFSET ?"
 	args = append(args, limit, offset)
 
-	rows, err := s.db.Query(query, args...)
+	rows, err := q.Query(query, args...)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -172,6 +229,10 @@ func (s *Server) handleGetTableData(c *gin.Context) {
 		}
 
 		row := map[string]interface{}{}
+		// The version covers the values as scanned, before BLOBs become text
+		if rel.rowEditable() {
+			row[rowVersionField] = rowVersion(columns, values)
+		}
 		for i, col := range columns {
 			row[col] = normalizeValue(values[i])
 		}
@@ -180,35 +241,91 @@ func (s *Server) handleGetTableData(c *gin.Context) {
 
 	// Get total count
 	totalQuery := fmt.Sprintf("SELECT COUNT(1) FROM %s", QuoteIdentifier(table))
+	totalArgs := []interface{}{}
 	if whereClause != "" {
 		totalQuery += " " + whereClause
-		totalArgs := args[:len(args)-2] // Remove limit and offset
-		var total int
-		if err := s.db.QueryRow(totalQuery, totalArgs...).Scan(&total); err != nil {
+		totalArgs = args[:len(args)-2] // Remove limit and offset
+	}
+	var total int
+	if err := q.QueryRow(totalQuery, totalArgs...).Scan(&total); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := gin.H{
+		"columns":  columns,
+		"rows":     data,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+		"type":     rel.Type,
+		"readOnly": rel.readOnly(),
+		"rowEdits": rel.rowEditable(),
+	}
+	// Optionally resolve foreign key values to labels of the referenced rows
+	if resolve, _ := strconv.ParseBool(c.DefaultQuery("resolve", "false")); resolve {
+		refs, err := s.resolveReferences(table, data)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{
-			"columns": columns,
-			"rows":    data,
-			"total":   total,
-			"limit":   limit,
-			"offset":  offset,
-		})
-	} else {
-		var total int
-		if err := s.db.QueryRow(totalQuery).Scan(&total); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		resp["references"] = refs
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// tableFilter holds the search and sort parameters shared by browsing and
+// exporting a table.
+type tableFilter struct {
+	Search   string
+	OrderBy  string
+	OrderDir string
+}
+
+func parseTableFilter(c *gin.Context) tableFilter {
+	f := tableFilter{
+		Search:   c.DefaultQuery("search", ""),
+		OrderBy:  c.DefaultQuery("orderBy", ""),
+		OrderDir: c.DefaultQuery("orderDir", "ASC"),
+	}
+	if f.OrderDir != "ASC" && f.OrderDir != "DESC" {
+		f.OrderDir = "ASC"
+	}
+	return f
+}
+
+// tableFilterClauses builds the WHERE and ORDER BY clauses for f. Search
+// matches any column with LIKE.
+func tableFilterClauses(q rowQuerier, table string, f tableFilter) (string, string, []interface{}) {
+	whereClause := ""
+	args := []interface{}{}
+	if f.Search != "" {
+		// Get all columns to search in
+		colRows, err := q.Query(fmt.Sprintf("PRAGMA table_info(%s)", QuoteIdentifier(table)))
+		if err == nil {
+			defer colRows.Close()
+			var searchConditions []string
+			for colRows.Next() {
+				var cid int
+				var colName, colType string
+				var notnull, pk int
+				var dflt sql.NullString
+				if err := colRows.Scan(&cid, &colName, &colType, &notnull, &dflt, &pk); err == nil {
+					searchConditions = append(searchConditions, fmt.Sprintf("%s LIKE ?", QuoteIdentifier(colName)))
+					args = append(args, "%"+f.Search+"%")
+				}
+			}
+			if len(searchConditions) > 0 {
+				whereClause = "WHERE (" + strings.Join(searchConditions, " OR ") + ")"
+			}
 		}
-		c.JSON(http.StatusOK, gin.H{
-			"columns": columns,
-			"rows":    data,
-			"total":   total,
-			"limit":   limit,
-			"offset":  offset,
-		})
 	}
+
+	orderClause := ""
+	if f.OrderBy != "" && IsSafeIdentifier(f.OrderBy) {
+		orderClause = fmt.Sprintf("ORDER BY %s %s", QuoteIdentifier(f.OrderBy), f.OrderDir)
+	}
+	return whereClause, orderClause, args
 }
 
 func (s *Server) handleUpdateRow(c *gin.Context) {
@@ -217,6 +334,9 @@ func (s *Server) handleUpdateRow(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid table name"})
 		return
 	}
+	if !s.requireWritable(c, table, true) {
+		return
+	}
 	rowidStr := c.Param("rowid")
 	rowid, err := strconv.ParseInt(rowidStr, 10, 64)
 	if err != nil || rowid <= 0 {
@@ -229,7 +349,9 @@ func (s *Server) handleUpdateRow(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
 		return
 	}
+	version := requestedRowVersion(c, payload)
 	delete(payload, "_rowid")
+	delete(payload, rowVersionField)
 	if len(payload) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "no columns to update"})
 		return
@@ -247,10 +369,21 @@ func (s *Server) handleUpdateRow(c *gin.Context) {
 	}
 	values = append(values, rowid)
 
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeFailed(c, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	before, ok := checkRowVersion(c, tx, table, rowid, version)
+	if !ok {
+		return
+	}
+
 	query := fmt.Sprintf("UPDATE %s SET %s WHERE rowid = ?", QuoteIdentifier(table), strings.Join(setClauses, ", "))
-	res, err := s.db.Exec(query, values...)
+	res, err := tx.Exec(query, values...)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeFailed(c, http.StatusBadRequest, err)
 		return
 	}
 	affected, _ := res.RowsAffected()
@@ -258,7 +391,17 @@ func (s *Server) handleUpdateRow(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "row not found"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "updated": affected})
+	after, err := loadRowSnapshot(tx, table, rowid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeFailed(c, http.StatusInternalServerError, err)
+		return
+	}
+	s.changes.record(sessionID(c), table, "update", rowid, before, after)
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "updated": affected, "row": after.row()})
 }
 
 func (s *Server) handleInsertRow(c *gin.Context) {
@@ -267,6 +410,9 @@ func (s *Server) handleInsertRow(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid table name"})
 		return
 	}
+	if !s.requireWritable(c, table, false) {
+		return
+	}
 
 	var payload map[string]interface{}
 	if err := c.BindJSON(&payload); err != nil {
@@ -296,13 +442,32 @@ func (s *Server) handleInsertRow(c *gin.Context) {
 		strings.Join(columns, ", "),
 		strings.Join(placeholders, ", "),
 	)
-	res, err := s.db.Exec(query, values...)
+	tx, err := s.db.Begin()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeFailed(c, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(query, values...)
+	if err != nil {
+		writeFailed(c, http.StatusBadRequest, err)
 		return
 	}
 	rowid, _ := res.LastInsertId()
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "rowid": rowid})
+	// WITHOUT ROWID tables cannot be read back by rowid; such inserts are
+	// kept but not recorded for undo.
+	after, loadErr := loadRowSnapshot(tx, table, rowid)
+	if err := tx.Commit(); err != nil {
+		writeFailed(c, http.StatusInternalServerError, err)
+		return
+	}
+	resp := gin.H{"status": "ok", "rowid": rowid}
+	if loadErr == nil {
+		s.changes.record(sessionID(c), table, "insert", rowid, nil, after)
+		resp["row"] = after.row()
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 func (s *Server) handleDeleteRow(c *gin.Context) {
@@ -311,6 +476,9 @@ func (s *Server) handleDeleteRow(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid table name"})
 		return
 	}
+	if !s.requireWritable(c, table, true) {
+		return
+	}
 	rowidStr := c.Param("rowid")
 	rowid, err := strconv.ParseInt(rowidStr, 10, 64)
 	if err != nil || rowid <= 0 {
@@ -318,9 +486,26 @@ func (s *Server) handleDeleteRow(c *gin.Context) {
 		return
 	}
 
-	res, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE rowid = ?", QuoteIdentifier(table)), rowid)
+	payload, err := readOptionalJSON(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeFailed(c, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+	before, ok := checkRowVersion(c, tx, table, rowid, requestedRowVersion(c, payload))
+	if !ok {
+		return
+	}
+
+	res, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE rowid = ?", QuoteIdentifier(table)), rowid)
+	if err != nil {
+		writeFailed(c, http.StatusBadRequest, err)
 		return
 	}
 	affected, _ := res.RowsAffected()
@@ -328,6 +513,11 @@ func (s *Server) handleDeleteRow(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "row not found"})
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		writeFailed(c, http.StatusInternalServerError, err)
+		return
+	}
+	s.changes.record(sessionID(c), table, "delete", rowid, before, nil)
 	c.JSON(http.StatusOK, gin.H{"status": "ok", "deleted": affected})
 }
 
@@ -338,22 +528,53 @@ func (s *Server) handleExportTable(c *gin.Context) {
 		return
 	}
 	format := c.DefaultQuery("format", "csv")
+	e, ok := prepareExporter(c, format, queryExportOptions(c))
+	if !ok {
+		return
+	}
+	comp, ok := parseCompression(c, c.Query("compress"))
+	if !ok {
+		return
+	}
 
-	switch format {
-	case "csv":
-		if err := s.exportCSV(c, table); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
-	case "json":
-		if err := s.exportJSON(c, table); err != nil {
+	rel, ok := s.findRelation(c, table)
+	if !ok {
+		return
+	}
+	q := s.querierFor(rel)
+
+	// A view cannot take the INSERTs of an SQL export, so they target a
+	// table with the view's columns
+	schema := rel.SQL
+	if rel.Type == "view" {
+		var err error
+		if schema, err = viewTableSchema(q, table); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
-	case "sql":
-		if err := s.exportSQL(c, table); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+
+	// Export what the browse view shows: the same search and sort, and a
+	// single page only when limit is given
+	whereClause, orderClause, args := tableFilterClauses(q, table, parseTableFilter(c))
+	query := fmt.Sprintf("SELECT * FROM %s %s %s", QuoteIdentifier(table), whereClause, orderClause)
+	if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 {
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if offset < 0 {
+			offset = 0
 		}
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported format"})
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+	}
+	rows, err := openRowStream(c.Request.Context(), q, query, args...)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	if err := writeExport(c, e, comp, exportSource{Name: table, Schema: schema}, rows); err != nil {
+		exportFailed(c, err)
 	}
 }
 
@@ -428,12 +649,9 @@ func IsSafeIdentifier(name string) bool {
 	return true
 }
 
-func (s *Server) fetchAllRows(table string) ([]string, []map[string]interface{}, error) {
-	if !IsSafeIdentifier(table) {
-		return nil, nil, fmt.Errorf("invalid table name")
-	}
-	query := fmt.Sprintf("SELECT * FROM %s", QuoteIdentifier(table))
-	rows, err := s.db.Query(query)
+// queryRows runs query and returns every row keyed by column name.
+func queryRows(q rowQuerier, query string, args ...interface{}) ([]string, []map[string]interface{}, error) {
+	rows, err := q.Query(query, args...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -466,18 +684,6 @@ func (s *Server) fetchAllRows(table string) ([]string, []map[string]interface{},
 	return columns, data, nil
 }
 
-func (s *Server) getTableSchema(table string) (string, error) {
-	var schema sql.NullString
-	err := s.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name=?`, table).Scan(&schema)
-	if err != nil {
-		return "", err
-	}
-	if !schema.Valid {
-		return "", errors.New("schema not found")
-	}
-	return schema.String, nil
-}
-
 func (s *Server) handleGetTableSchema(c *gin.Context) {
 	table := c.Param("table")
 	if !IsSafeIdentifier(table) {
@@ -486,14 +692,14 @@ func (s *Server) handleGetTableSchema(c *gin.Context) {
 	}
 
 	// Get table schema SQL
-	schema, err := s.getTableSchema(table)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	rel, ok := s.findRelation(c, table)
+	if !ok {
 		return
 	}
+	schema := rel.SQL
 
 	// Get column info using PRAGMA
-	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", QuoteIdentifier(table)))
+	rows, err := s.querierFor(rel).Query(fmt.Sprintf("PRAGMA table_info(%s)", QuoteIdentifier(table)))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -523,6 +729,24 @@ func (s *Server) handleGetTableSchema(c *gin.Context) {
 		columns = append(columns, col)
 	}
 
+	// Views have no indexes, keys or editable definition
+	if rel.Type == "view" {
+		triggers, err := s.triggers(table)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"schema":   schema,
+			"type":     rel.Type,
+			"temp":     rel.Temp,
+			"readOnly": true,
+			"columns":  columns,
+			"triggers": triggers,
+		})
+		return
+	}
+
 	// Get indexes
 	indexRows, err := s.db.Query(`SELECT name, sql FROM sqlite_master WHERE type='index' AND tbl_name=?`, table)
 	if err != nil {
@@ -549,11 +773,59 @@ func (s *Server) handleGetTableSchema(c *gin.Context) {
 		indexes = append(indexes, idx)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"schema":  schema,
-		"columns": columns,
-		"indexes": indexes,
-	})
+	foreignKeys, err := s.foreignKeys(table)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	referencedBy, err := s.incomingForeignKeys(table)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	triggers, err := s.triggers(table)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := gin.H{
+		"schema":       schema,
+		"type":         rel.Type,
+		"temp":         rel.Temp,
+		"readOnly":     rel.readOnly(),
+		"rowEdits":     rel.rowEditable(),
+		"columns":      columns,
+		"indexes":      indexes,
+		"foreignKeys":  foreignKeys,
+		"referencedBy": referencedBy,
+		"triggers":     triggers,
+	}
+	switch {
+	case rel.Type == "virtual":
+		info, err := s.virtualTableInfo(rel)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		resp["virtual"] = info
+	case rel.Type == "shadow":
+		owner, err := s.shadowOwner(table)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		resp["owner"] = owner
+	case !rel.Temp:
+		// The definition as PATCH /schema takes it, for editing
+		state, err := s.loadTableState(table)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		resp["definition"] = state.Def
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 func (s *Server) handleExecuteQuery(c *gin.Context) {
@@ -574,8 +846,10 @@ func (s *Server) handleExecuteQuery(c *gin.Context) {
 	queryUpper := strings.ToUpper(strings.TrimSpace(req.Query))
 	isSelect := strings.HasPrefix(queryUpper, "SELECT") || strings.HasPrefix(queryUpper, "WITH")
 
+	// The console runs on the session connection so temp tables and views
+	// it creates can be browsed afterwards
 	if isSelect {
-		rows, err := s.db.Query(req.Query)
+		rows, err := s.session.Query(req.Query)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
@@ -607,6 +881,7 @@ func (s *Server) handleExecuteQuery(c *gin.Context) {
 			data = append(data, row)
 		}
 
+		s.queries.add(req.Query)
 		c.JSON(http.StatusOK, gin.H{
 			"columns": columns,
 			"rows":    data,
@@ -614,7 +889,7 @@ func (s *Server) handleExecuteQuery(c *gin.Context) {
 		})
 	} else {
 		// Execute write operations (INSERT, UPDATE, DELETE, etc.)
-		result, err := s.db.Exec(req.Query)
+		result, err := s.session.Exec(req.Query)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
@@ -663,7 +938,12 @@ func (s *Server) handleListIndexes(c *gin.Context) {
 }
 
 func (s *Server) handleListViews(c *gin.Context) {
-	rows, err := s.db.Query(`SELECT name, sql FROM sqlite_master WHERE type='view' ORDER BY name`)
+	// Temp views are only visible on the session connection
+	rows, err := s.session.Query(`
+		SELECT name, sql, 0 FROM sqlite_master WHERE type='view'
+		UNION ALL
+		SELECT name, sql, 1 FROM sqlite_temp_master WHERE type='view'
+		ORDER BY name`)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -671,15 +951,17 @@ func (s *Server) handleListViews(c *gin.Context) {
 	defer rows.Close()
 
 	type View struct {
-		Name string `json:"name"`
-		SQL  string `json:"sql"`
+		Name    string   `json:"name"`
+		SQL     string   `json:"sql"`
+		Temp    bool     `json:"temp"`
+		Columns []string `json:"columns"`
 	}
 
 	var views []View
 	for rows.Next() {
 		var v View
 		var sqlStr sql.NullString
-		if err := rows.Scan(&v.Name, &sqlStr); err != nil {
+		if err := rows.Scan(&v.Name, &sqlStr, &v.Temp); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -688,6 +970,20 @@ func (s *Server) handleListViews(c *gin.Context) {
 		}
 		views = append(views, v)
 	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rows.Close()
+
+	// A view whose tables were dropped has no columns; list it anyway
+	for i := range views {
+		q := rowQuerier(s.db)
+		if views[i].Temp {
+			q = s.session
+		}
+		views[i].Columns, _ = viewColumns(q, views[i].Name)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"views": views})
 }
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type spaceUsage struct {
+	Name          string  `json:"name"`
+	Type          string  `json:"type"`
+	Table         string  `json:"table"`
+	Pages         int64   `json:"pages"`
+	Bytes         int64   `json:"bytes"`
+	PayloadBytes  int64   `json:"payloadBytes"`
+	UnusedBytes   int64   `json:"unusedBytes"`
+	LeafPages     int64   `json:"leafPages"`
+	InteriorPages int64   `json:"interiorPages"`
+	OverflowPages int64   `json:"overflowPages"`
+	Percent       float64 `json:"percent"`
+}
+
+func (s *Server) handleSpaceStats(c *gin.Context) {
+	var pageSize, pageCount, freelistCount int64
+	for query, dest := range map[string]*int64{
+		"PRAGMA page_size":      &pageSize,
+		"PRAGMA page_count":     &pageCount,
+		"PRAGMA freelist_count": &freelistCount,
+	} {
+		if err := s.db.QueryRow(query).Scan(dest); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	objects, err := s.schemaObjects()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var hasDBStat bool
+	if err := s.db.QueryRow(`SELECT COUNT(1) > 0 FROM pragma_module_list WHERE name = 'dbstat'`).Scan(&hasDBStat); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	source := "dbstat"
+	var usage []spaceUsage
+	if hasDBStat {
+		usage, err = s.spaceFromDBStat(objects)
+	} else {
+		// Builds without the dbstat virtual table only allow an estimate
+		source = "estimate"
+		usage, err = s.estimateSpace(objects, pageSize)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	fileBytes := pageSize * pageCount
+	for i := range usage {
+		if fileBytes > 0 {
+			usage[i].Percent = float64(usage[i].Bytes) * 100 / float64(fileBytes)
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Bytes != usage[j].Bytes {
+			return usage[i].Bytes > usage[j].Bytes
+		}
+		return usage[i].Name < usage[j].Name
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"source":        source,
+		"pageSize":      pageSize,
+		"pageCount":     pageCount,
+		"freelistPages": freelistCount,
+		"fileBytes":     fileBytes,
+		"freeBytes":     freelistCount * pageSize,
+		"objects":       usage,
+	})
+}
+
+type schemaObject struct {
+	Name  string
+	Type  string
+	Table string
+}
+
+// schemaObjects lists the tables and indexes that own b-trees, including
+// the schema table itself.
+func (s *Server) schemaObjects() ([]schemaObject, error) {
+	rows, err := s.db.Query(`SELECT name, type, tbl_name FROM sqlite_schema WHERE type IN ('table', 'index') AND rootpage > 0 ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	objects := []schemaObject{{Name: "sqlite_schema", Type: "table", Table: "sqlite_schema"}}
+	for rows.Next() {
+		var obj schemaObject
+		if err := rows.Scan(&obj.Name, &obj.Type, &obj.Table); err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+func (s *Server) spaceFromDBStat(objects []schemaObject) ([]spaceUsage, error) {
+	rows, err := s.db.Query(`SELECT name, COUNT(*), SUM(pgsize), SUM(payload), SUM(unused),
+		SUM(pagetype = 'leaf'), SUM(pagetype = 'internal'), SUM(pagetype = 'overflow')
+		FROM dbstat GROUP BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byName := map[string]spaceUsage{}
+	for rows.Next() {
+		var u spaceUsage
+		if err := rows.Scan(&u.Name, &u.Pages, &u.Bytes, &u.PayloadBytes, &u.UnusedBytes, &u.LeafPages, &u.InteriorPages, &u.OverflowPages); err != nil {
+			return nil, err
+		}
+		byName[u.Name] = u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	usage := make([]spaceUsage, 0, len(objects))
+	for _, obj := range objects {
+		u := byName[obj.Name]
+		u.Name, u.Type, u.Table = obj.Name, obj.Type, obj.Table
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
+
+// estimateSpace approximates each object's size from the length of its
+// stored values, rounded up to whole pages.
+func (s *Server) estimateSpace(objects []schemaObject, pageSize int64) ([]spaceUsage, error) {
+	usage := make([]spaceUsage, 0, len(objects))
+	for _, obj := range objects {
+		var cols []string
+		var err error
+		if obj.Type == "index" {
+			cols, err = s.pragmaNames("index_info", obj.Name)
+		} else {
+			cols, err = s.pragmaNames("table_info", obj.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		exprs := []string{"0"}
+		for _, col := range cols {
+			exprs = append(exprs, fmt.Sprintf("IFNULL(length(%s), 0)", QuoteIdentifier(col)))
+		}
+
+		var payload sql.NullInt64
+		query := fmt.Sprintf("SELECT SUM(%s) FROM %s", strings.Join(exprs, " + "), QuoteIdentifier(obj.Table))
+		if err := s.db.QueryRow(query).Scan(&payload); err != nil {
+			return nil, err
+		}
+		pages := (payload.Int64 + pageSize - 1) / pageSize
+		if pages == 0 {
+			pages = 1
+		}
+		usage = append(usage, spaceUsage{
+			Name:         obj.Name,
+			Type:         obj.Type,
+			Table:        obj.Table,
+			Pages:        pages,
+			Bytes:        pages * pageSize,
+			PayloadBytes: payload.Int64,
+			UnusedBytes:  pages*pageSize - payload.Int64,
+			LeafPages:    pages,
+		})
+	}
+	return usage, nil
+}
+
+// pragmaNames returns the name column of a table-valued pragma such as
+// table_info or index_info. Expression index columns have no name and are
+// skipped.
+func (s *Server) pragmaNames(pragma, arg string) ([]string, error) {
+	rows, err := s.db.Query(fmt.Sprintf("SELECT name FROM pragma_%s(?)", pragma), arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name sql.NullString
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if name.Valid {
+			names = append(names, name.String)
+		}
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpaceStats(t *testing.T) {
+	const schema = `
+CREATE TABLE big(id INTEGER PRIMARY KEY, body TEXT);
+CREATE INDEX big_body ON big(body);
+CREATE TABLE small(x);
+WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 500)
+INSERT INTO big(body) SELECT printf('%0500d', i) FROM n;`
+
+	s := newTestServer(t, schema, Options{})
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stats/space", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Source  string       `json:"source"`
+		Objects []spaceUsage `json:"objects"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	// The bundled SQLite is built with dbstat
+	if resp.Source != "dbstat" {
+		t.Errorf("source = %q, want dbstat", resp.Source)
+	}
+
+	byName := map[string]spaceUsage{}
+	for _, u := range resp.Objects {
+		byName[u.Name] = u
+	}
+	tests := []struct {
+		name     string
+		table    string
+		minPages int64
+	}{
+		{name: "big", table: "big", minPages: 60},
+		{name: "big_body", table: "big", minPages: 60},
+		{name: "small", table: "small", minPages: 1},
+		{name: "sqlite_schema", table: "sqlite_schema", minPages: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, ok := byName[tt.name]
+			if !ok {
+				t.Fatalf("%s missing from %v", tt.name, resp.Objects)
+			}
+			if u.Table != tt.table {
+				t.Errorf("table = %q, want %q", u.Table, tt.table)
+			}
+			if u.Pages < tt.minPages {
+				t.Errorf("pages = %d, want at least %d", u.Pages, tt.minPages)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// triggerInfo describes a trigger. Timing and event are read from its SQL
+// since SQLite stores nothing else about them.
+type triggerInfo struct {
+	Name   string `json:"name"`
+	Table  string `json:"table"`
+	Timing string `json:"timing"`
+	Event  string `json:"event"`
+	// Columns lists the columns of an UPDATE OF trigger.
+	Columns []string `json:"columns,omitempty"`
+	SQL     string   `json:"sql"`
+}
+
+// triggers lists the triggers of table, or of all tables and views if
+// table is empty.
+func (s *Server) triggers(table string) ([]triggerInfo, error) {
+	query := `SELECT name, tbl_name, sql FROM sqlite_schema WHERE type = 'trigger'`
+	var args []interface{}
+	if table != "" {
+		query += ` AND tbl_name = ?`
+		args = append(args, table)
+	}
+	rows, err := s.db.Query(query+` ORDER BY tbl_name, name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	triggers := []triggerInfo{}
+	for rows.Next() {
+		var t triggerInfo
+		var stmt sql.NullString
+		if err := rows.Scan(&t.Name, &t.Table, &stmt); err != nil {
+			return nil, err
+		}
+		t.SQL = stmt.String
+		t.Timing, t.Event, t.Columns = parseTriggerHeader(t.SQL)
+		triggers = append(triggers, t)
+	}
+	return triggers, rows.Err()
+}
+
+// parseTriggerHeader reads the timing, event and UPDATE OF columns from
+// CREATE TRIGGER. A trigger without a timing fires BEFORE.
+func parseTriggerHeader(stmt string) (timing, event string, columns []string) {
+	timing = "BEFORE"
+	tokens := tokenizeSQL(stmt)
+	for i := 0; i < len(tokens); i++ {
+		word := strings.ToUpper(tokens[i].text)
+		if tokens[i].kind != tokenWord {
+			continue
+		}
+		switch word {
+		case "BEFORE", "AFTER":
+			timing = word
+		case "INSTEAD":
+			timing = "INSTEAD OF"
+		case "DELETE", "INSERT":
+			return timing, word, nil
+		case "UPDATE":
+			event = word
+			if i+1 < len(tokens) && strings.EqualFold(tokens[i+1].text, "OF") {
+				for i += 2; i < len(tokens); i++ {
+					if tokens[i].text == "," {
+						continue
+					}
+					if strings.EqualFold(tokens[i].text, "ON") {
+						break
+					}
+					columns = append(columns, tokens[i].text)
+				}
+			}
+			return timing, event, columns
+		}
+	}
+	return timing, event, columns
+}
+
+func (s *Server) handleListTriggers(c *gin.Context) {
+	table := c.Query("table")
+	if table != "" && !IsSafeIdentifier(table) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid table name"})
+		return
+	}
+	triggers, err := s.triggers(table)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"triggers": triggers})
+}
+
+// triggerDefinition describes a trigger to create. Body holds the
+// statements between BEGIN and END.
+type triggerDefinition struct {
+	Name    string   `json:"name"`
+	Table   string   `json:"table"`
+	Timing  string   `json:"timing"`
+	Event   string   `json:"event"`
+	Columns []string `json:"columns"`
+	When    string   `json:"when"`
+	Body    string   `json:"body"`
+}
+
+// createTriggerSQL validates def and generates its CREATE TRIGGER
+// statement.
+func createTriggerSQL(def triggerDefinition) (string, error) {
+	if !IsSafeIdentifier(def.Name) {
+		return "", fmt.Errorf("invalid trigger name: %s", def.Name)
+	}
+	if !IsSafeIdentifier(def.Table) {
+		return "", fmt.Errorf("invalid table name: %s", def.Table)
+	}
+	timing := strings.ToUpper(strings.TrimSpace(def.Timing))
+	switch timing {
+	case "":
+		timing = "AFTER"
+	case "BEFORE", "AFTER", "INSTEAD OF":
+	default:
+		return "", errors.New("timing must be BEFORE, AFTER or INSTEAD OF")
+	}
+	event := strings.ToUpper(strings.TrimSpace(def.Event))
+	switch event {
+	case "INSERT", "DELETE":
+		if len(def.Columns) > 0 {
+			return "", errors.New("columns only apply to UPDATE triggers")
+		}
+	case "UPDATE":
+		if len(def.Columns) > 0 {
+			quoted := make([]string, len(def.Columns))
+			for i, col := range def.Columns {
+				if !IsSafeIdentifier(col) {
+					return "", fmt.Errorf("invalid column name: %s", col)
+				}
+				quoted[i] = QuoteIdentifier(col)
+			}
+			event += " OF " + strings.Join(quoted, ", ")
+		}
+	default:
+		return "", errors.New("event must be INSERT, UPDATE or DELETE")
+	}
+
+	body := strings.TrimSpace(def.Body)
+	if body == "" {
+		return "", errors.New("body cannot be empty")
+	}
+	if err := checkTriggerBody(body); err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(body, ";") {
+		body += ";"
+	}
+
+	stmt := fmt.Sprintf("CREATE TRIGGER %s %s %s ON %s FOR EACH ROW", QuoteIdentifier(def.Name), timing, event, QuoteIdentifier(def.Table))
+	if def.When != "" {
+		if err := checkSQLExpression(def.When); err != nil {
+			return "", fmt.Errorf("when clause: %w", err)
+		}
+		stmt += " WHEN " + def.When
+	}
+	return stmt + "\nBEGIN\n  " + body + "\nEND", nil
+}
+
+// checkTriggerBody rejects an END that would close the trigger early, so
+// the body cannot carry statements outside it. END also closes CASE.
+func checkTriggerBody(body string) error {
+	cases := 0
+	for _, t := range tokenizeSQL(body) {
+		if t.kind != tokenWord {
+			continue
+		}
+		switch strings.ToUpper(t.text) {
+		case "CASE":
+			cases++
+		case "END":
+			if cases == 0 {
+				return errors.New("body cannot contain END outside a CASE expression")
+			}
+			cases--
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleCreateTrigger(c *gin.Context) {
+	var req struct {
+		triggerDefinition
+		DryRun bool `json:"dryRun"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+		return
+	}
+	stmt, err := createTriggerSQL(req.triggerDefinition)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"sql": stmt, "dryRun": true})
+		return
+	}
+	if _, err := s.db.Exec(stmt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "sql": stmt})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"sql": stmt, "name": req.Name})
+}
+
+func (s *Server) handleDropTrigger(c *gin.Context) {
+	name := c.Param("trigger")
+	if !IsSafeIdentifier(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid trigger name"})
+		return
+	}
+	var stmt string
+	err := s.db.QueryRow(`SELECT sql FROM sqlite_schema WHERE type = 'trigger' AND name = ?`, name).Scan(&stmt)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "trigger not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := s.db.Exec("DROP TRIGGER " + QuoteIdentifier(name)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dropped": name, "sql": stmt})
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// relation is a table or view that can be browsed.
+type relation struct {
+	Name string
+	// Type is table, view, virtual or shadow, as in PRAGMA table_list.
+	Type string
+	// Temp is set for objects in the temp schema.
+	Temp         bool
+	WithoutRowid bool
+	SQL          string
+}
+
+// hasRowid reports whether rows can be addressed by rowid.
+func (r relation) hasRowid() bool {
+	return (r.Type == "table" || r.Type == "virtual" || r.Type == "shadow") && !r.WithoutRowid
+}
+
+// readOnly reports whether the relation takes no row writes at all: views,
+// shadow tables, which belong to their virtual table, and temp objects,
+// which exist only on the session connection the write handlers do not use.
+func (r relation) readOnly() bool {
+	return r.Type == "view" || r.Type == "shadow" || r.Temp
+}
+
+// rowEditable reports whether rows can be updated and deleted by rowid and
+// so carry a version token. WITHOUT ROWID tables only accept inserts.
+func (r relation) rowEditable() bool {
+	return r.hasRowid() && !r.readOnly()
+}
+
+// lookupRelation finds the table or view called name. Main-schema objects
+// are resolved on the pool, as the pool's unqualified names are, so a busy
+// SQL console does not stall browsing; only names missing there are looked
+// up among the temp objects of the session connection.
+func (s *Server) lookupRelation(name string) (relation, error) {
+	r, err := queryRelation(s.db, "main", name)
+	if errors.Is(err, sql.ErrNoRows) {
+		r, err = queryRelation(s.session, "temp", name)
+	}
+	return r, err
+}
+
+func queryRelation(q relationQuerier, schema, name string) (relation, error) {
+	r := relation{Name: name, Temp: schema == "temp"}
+	master := "sqlite_schema"
+	if r.Temp {
+		master = "sqlite_temp_schema"
+	}
+	var stmt sql.NullString
+	err := q.QueryRow(fmt.Sprintf(`
+		SELECT l.type, l.wr, m.sql
+		FROM pragma_table_list AS l
+		JOIN %s AS m ON m.name = l.name
+		WHERE l.schema = ? AND l.name = ?`, master), schema, name).Scan(&r.Type, &r.WithoutRowid, &stmt)
+	if err != nil {
+		return r, err
+	}
+	r.SQL = stmt.String
+	return r, nil
+}
+
+// relationQuerier runs the reads needed to browse a relation.
+type relationQuerier interface {
+	rowQuerier
+	contextQuerier
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// querierFor returns where r can be read: temp objects only exist on the
+// session connection, everything else is read through the pool.
+func (s *Server) querierFor(r relation) relationQuerier {
+	if r.Temp {
+		return s.session
+	}
+	return s.db
+}
+
+// sessionConn is a connection pinned for the SQL console. Temp tables and
+// views exist only on the connection that created them, so running the
+// console on one connection keeps them visible to later requests.
+type sessionConn struct {
+	*sql.Conn
+}
+
+func openSession(db *sql.DB) (sessionConn, error) {
+	conn, err := db.Conn(context.Background())
+	return sessionConn{conn}, err
+}
+
+func (c sessionConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+func (c sessionConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.QueryRowContext(context.Background(), query, args...)
+}
+
+func (c sessionConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+// findRelation looks up the relation for a handler, responding 404 if it
+// does not exist.
+func (s *Server) findRelation(c *gin.Context, name string) (relation, bool) {
+	r, err := s.lookupRelation(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "table not found"})
+		return r, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return r, false
+	}
+	return r, true
+}
+
+// requireWritable rejects row writes on read-only relations, and with
+// byRowid also updates and deletes on tables without a rowid. Unknown
+// names are left for the statement to report.
+func (s *Server) requireWritable(c *gin.Context, table string, byRowid bool) bool {
+	r, err := s.lookupRelation(table)
+	switch {
+	case err != nil:
+		return true
+	case r.readOnly():
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": fmt.Sprintf("%s is read-only", r.Name)})
+		return false
+	case byRowid && !r.rowEditable():
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": fmt.Sprintf("%s has no rowid; rows can only be inserted", r.Name)})
+		return false
+	}
+	return true
+}
+
+// viewTableSchema returns a CREATE TABLE statement with the columns of a
+// view, so an SQL export of the view can be loaded as a table.
+func viewTableSchema(q rowQuerier, view string) (string, error) {
+	rows, err := q.Query(`SELECT name, type FROM pragma_table_info(?) ORDER BY cid`, view)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var defs []string
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return "", err
+		}
+		defs = append(defs, strings.TrimSpace(QuoteIdentifier(name)+" "+typ))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("CREATE TABLE %s (%s)", QuoteIdentifier(view), strings.Join(defs, ", ")), nil
+}
+
+// viewColumns lists the column names of a view with PRAGMA table_info.
+func viewColumns(q rowQuerier, view string) ([]string, error) {
+	rows, err := q.Query(`SELECT name FROM pragma_table_info(?) ORDER BY cid`, view)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
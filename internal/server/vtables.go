@@ -0,0 +1,351 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tableListEntry is a table as PRAGMA table_list reports it.
+type tableListEntry struct {
+	Name string `json:"name"`
+	// Type is table, virtual or shadow.
+	Type         string `json:"type"`
+	Columns      int    `json:"columns"`
+	WithoutRowid bool   `json:"withoutRowid"`
+	Strict       bool   `json:"strict"`
+	// Module is the module of a virtual table, such as fts5 or rtree.
+	Module string `json:"module,omitempty"`
+	// Shadows lists the tables a virtual table keeps its data in.
+	Shadows []string `json:"shadows,omitempty"`
+}
+
+// tableList lists the tables of the main schema with shadow tables
+// grouped under the virtual table owning them. A shadow table whose owner
+// cannot be found is listed on its own.
+func (s *Server) tableList() ([]tableListEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT l.name, l.type, l.ncol, l.wr, l.strict, m.sql
+		FROM pragma_table_list AS l
+		JOIN sqlite_schema AS m ON m.name = l.name
+		WHERE l.schema = 'main' AND l.type IN ('table', 'virtual', 'shadow') AND l.name NOT LIKE 'sqlite_%'
+		ORDER BY l.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []tableListEntry
+	for rows.Next() {
+		var e tableListEntry
+		var stmt sql.NullString
+		if err := rows.Scan(&e.Name, &e.Type, &e.Columns, &e.WithoutRowid, &e.Strict, &stmt); err != nil {
+			return nil, err
+		}
+		if e.Type == "virtual" {
+			e.Module, _ = virtualTableArgs(stmt.String)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	owners := map[string]bool{}
+	for _, e := range entries {
+		if e.Type == "virtual" {
+			owners[e.Name] = true
+		}
+	}
+	list := []tableListEntry{}
+	var shadows []tableListEntry
+	for _, e := range entries {
+		if e.Type == "shadow" {
+			shadows = append(shadows, e)
+			continue
+		}
+		list = append(list, e)
+	}
+	for _, shadow := range shadows {
+		owner := shadowOwnerName(shadow.Name, owners)
+		if owner == "" {
+			list = append(list, shadow)
+			continue
+		}
+		for i := range list {
+			if list[i].Name == owner {
+				list[i].Shadows = append(list[i].Shadows, shadow.Name)
+			}
+		}
+	}
+	return list, nil
+}
+
+// shadowOwnerName picks the virtual table whose name, followed by an
+// underscore, is the longest prefix of shadow. Shadow tables are named
+// <table>_<suffix>, and a virtual table may itself contain underscores.
+func shadowOwnerName(shadow string, virtual map[string]bool) string {
+	owner := ""
+	for name := range virtual {
+		if strings.HasPrefix(shadow, name+"_") && len(name) > len(owner) {
+			owner = name
+		}
+	}
+	return owner
+}
+
+// shadowOwner returns the virtual table owning a shadow table.
+func (s *Server) shadowOwner(shadow string) (string, error) {
+	rows, err := s.db.Query(`SELECT name FROM pragma_table_list WHERE schema = 'main' AND type = 'virtual'`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	virtual := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", err
+		}
+		virtual[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return shadowOwnerName(shadow, virtual), nil
+}
+
+var usingPattern = regexp.MustCompile(`(?is)\bUSING\s+("[^"]+"|\w+)\s*(?:\((.*)\))?\s*;?\s*$`)
+
+// virtualTableArgs reads the module and its arguments from CREATE
+// VIRTUAL TABLE. Arguments are split on top-level commas and trimmed but
+// otherwise kept as written.
+func virtualTableArgs(stmt string) (string, []string) {
+	m := usingPattern.FindStringSubmatch(stmt)
+	if m == nil {
+		return "", nil
+	}
+	args, _ := splitSQLList(m[2])
+	return strings.ToLower(strings.Trim(m[1], `"`)), args
+}
+
+// splitSQLList splits a parenthesised SQL list, starting after its opening
+// parenthesis, on top-level commas. It stops at the closing parenthesis and
+// returns the offset just past it, or len(s) if there is none. Quotes and
+// comments are skipped over but kept in the items.
+func splitSQLList(s string) ([]string, int) {
+	var items []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '\'' || ch == '"' || ch == '`' || ch == '[':
+			end := ch
+			if ch == '[' {
+				end = ']'
+			}
+			for i++; i < len(s); i++ {
+				if s[i] == end {
+					// A doubled quote stands for itself
+					if end != ']' && i+1 < len(s) && s[i+1] == end {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		case ch == '-' && strings.HasPrefix(s[i:], "--"):
+			if n := strings.IndexByte(s[i:], '\n'); n >= 0 {
+				i += n
+			} else {
+				i = len(s)
+			}
+		case ch == '/' && strings.HasPrefix(s[i:], "/*"):
+			if n := strings.Index(s[i+2:], "*/"); n >= 0 {
+				i += n + 3
+			} else {
+				i = len(s)
+			}
+		case ch == '(':
+			depth++
+		case ch == ')':
+			if depth == 0 {
+				if item := strings.TrimSpace(s[start:i]); item != "" {
+					items = append(items, item)
+				}
+				return items, i + 1
+			}
+			depth--
+		case ch == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if item := strings.TrimSpace(s[start:]); item != "" {
+		items = append(items, item)
+	}
+	return items, len(s)
+}
+
+// unquoteSQL strips one level of SQL quoting from s.
+func unquoteSQL(s string) string {
+	if len(s) >= 2 {
+		switch s[0] {
+		case '\'', '"', '`':
+			if s[len(s)-1] == s[0] {
+				q := string(s[0])
+				return strings.ReplaceAll(s[1:len(s)-1], q+q, q)
+			}
+		case '[':
+			if s[len(s)-1] == ']' {
+				return s[1 : len(s)-1]
+			}
+		}
+	}
+	return s
+}
+
+// virtualTable describes a virtual table for the schema endpoint.
+type virtualTable struct {
+	Module  string     `json:"module"`
+	Args    []string   `json:"args"`
+	Shadows []string   `json:"shadows"`
+	FTS5    *fts5Table `json:"fts5,omitempty"`
+	RTree   *rtreeInfo `json:"rtree,omitempty"`
+}
+
+// fts5Table holds the columns and options of an FTS5 table. Options are
+// those given at creation, such as tokenize, prefix and content; Config
+// holds the rows of the <table>_config shadow table, which record options
+// changed later with INSERT INTO t(t, rank).
+type fts5Table struct {
+	Columns []fts5Column           `json:"columns"`
+	Options map[string]string      `json:"options"`
+	Config  map[string]interface{} `json:"config"`
+}
+
+type fts5Column struct {
+	Name      string `json:"name"`
+	Unindexed bool   `json:"unindexed"`
+}
+
+// rtreeInfo holds the dimensions of an R*Tree table and the bounding box
+// of all its entries.
+type rtreeInfo struct {
+	Dimensions int `json:"dimensions"`
+	// Integer is set for rtree_i32 tables, which store 32-bit integer
+	// coordinates instead of 32-bit floats.
+	Integer   bool         `json:"integer"`
+	ID        string       `json:"id"`
+	Auxiliary []string     `json:"auxiliary"`
+	Entries   int64        `json:"entries"`
+	Bounds    []rtreeBound `json:"bounds"`
+}
+
+// rtreeBound is the extent of one dimension; Min and Max are nil for an
+// empty tree.
+type rtreeBound struct {
+	MinColumn string      `json:"minColumn"`
+	MaxColumn string      `json:"maxColumn"`
+	Min       interface{} `json:"min"`
+	Max       interface{} `json:"max"`
+}
+
+func (s *Server) virtualTableInfo(rel relation) (*virtualTable, error) {
+	module, args := virtualTableArgs(rel.SQL)
+	info := &virtualTable{Module: module, Args: args, Shadows: []string{}}
+	if info.Args == nil {
+		info.Args = []string{}
+	}
+	list, err := s.tableList()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range list {
+		if e.Name == rel.Name && e.Shadows != nil {
+			info.Shadows = e.Shadows
+		}
+	}
+
+	switch module {
+	case "fts5":
+		info.FTS5, err = s.fts5Info(rel.Name, args)
+	case "rtree", "rtree_i32":
+		info.RTree, err = s.rtreeInfo(rel.Name, module, args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (s *Server) fts5Info(table string, args []string) (*fts5Table, error) {
+	info := &fts5Table{Columns: []fts5Column{}, Options: map[string]string{}, Config: map[string]interface{}{}}
+	for _, arg := range args {
+		if key, value, ok := strings.Cut(arg, "="); ok {
+			info.Options[strings.ToLower(strings.TrimSpace(key))] = unquoteSQL(strings.TrimSpace(value))
+			continue
+		}
+		fields := strings.Fields(arg)
+		if len(fields) == 0 {
+			continue
+		}
+		col := fts5Column{Name: unquoteSQL(fields[0])}
+		col.Unindexed = len(fields) > 1 && strings.EqualFold(fields[len(fields)-1], "UNINDEXED")
+		info.Columns = append(info.Columns, col)
+	}
+
+	_, config, err := queryRows(s.db, fmt.Sprintf("SELECT k, v FROM %s", QuoteIdentifier(table+"_config")))
+	if err != nil {
+		return nil, fmt.Errorf("read fts5 config of %s: %w", table, err)
+	}
+	for _, row := range config {
+		info.Config[fmt.Sprint(row["k"])] = row["v"]
+	}
+	return info, nil
+}
+
+func (s *Server) rtreeInfo(table, module string, args []string) (*rtreeInfo, error) {
+	info := &rtreeInfo{Integer: module == "rtree_i32", Auxiliary: []string{}, Bounds: []rtreeBound{}}
+	var coords []string
+	for _, arg := range args {
+		// Auxiliary columns are marked with a leading +
+		if strings.HasPrefix(arg, "+") {
+			fields := strings.Fields(strings.TrimPrefix(arg, "+"))
+			if len(fields) > 0 {
+				info.Auxiliary = append(info.Auxiliary, unquoteSQL(fields[0]))
+			}
+			continue
+		}
+		if fields := strings.Fields(arg); len(fields) > 0 {
+			coords = append(coords, unquoteSQL(fields[0]))
+		}
+	}
+	if len(coords) == 0 {
+		return info, nil
+	}
+	info.ID, coords = coords[0], coords[1:]
+	info.Dimensions = len(coords) / 2
+
+	terms := []string{"count(*)"}
+	for d := 0; d < info.Dimensions; d++ {
+		minCol, maxCol := coords[2*d], coords[2*d+1]
+		info.Bounds = append(info.Bounds, rtreeBound{MinColumn: minCol, MaxColumn: maxCol})
+		terms = append(terms, "min("+QuoteIdentifier(minCol)+")", "max("+QuoteIdentifier(maxCol)+")")
+	}
+	values := make([]interface{}, len(terms))
+	ptrs := make([]interface{}, len(terms))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(terms, ", "), QuoteIdentifier(table))
+	if err := s.db.QueryRow(query).Scan(ptrs...); err != nil {
+		return nil, fmt.Errorf("read rtree bounds of %s: %w", table, err)
+	}
+	info.Entries, _ = values[0].(int64)
+	for d := range info.Bounds {
+		info.Bounds[d].Min = normalizeValue(values[1+2*d])
+		info.Bounds[d].Max = normalizeValue(values[2+2*d])
+	}
+	return info, nil
+}
@@ -0,0 +1,659 @@
+package server
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The XLSX support covers what table exports and imports need: inline
+// string, number and date cells written as a stream, and reading the
+// values of one sheet. Formulas, merged cells and formatting beyond dates
+// are out of scope.
+
+const (
+	xlsxMaxCellText  = 32767
+	xlsxMaxSheetName = 31
+
+	// Cell styles defined in xlsxStyles
+	xlsxStyleDate     = 1
+	xlsxStyleDateTime = 2
+	xlsxStyleHeader   = 3
+)
+
+// xlsxEpoch is day zero of the 1900 date system, shifted to absorb the
+// 1900 leap year bug for all dates after February 1900.
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// Kinds of number formats that show a date or time, as read on import
+const (
+	xlsxFormatDate = iota + 1
+	xlsxFormatDateTime
+	xlsxFormatTime
+)
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="2"><numFmt numFmtId="164" formatCode="yyyy-mm-dd"/><numFmt numFmtId="165" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="4"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/><xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>
+</styleSheet>`
+
+// xlsxWriter streams a workbook. Sheets are written one after another;
+// the workbook parts that list them are added by Close.
+type xlsxWriter struct {
+	zip     *zip.Writer
+	created time.Time
+	sheet   io.Writer
+	sheets  []string
+	row     int
+	cell    []byte
+}
+
+func newXLSXWriter(w io.Writer) *xlsxWriter {
+	return &xlsxWriter{zip: zip.NewWriter(w), created: time.Now()}
+}
+
+// StartSheet ends the current sheet and begins a new one with a bold,
+// frozen header row.
+func (xw *xlsxWriter) StartSheet(name string, columns []string) error {
+	if err := xw.endSheet(); err != nil {
+		return err
+	}
+	name = xlsxSheetName(name, xw.sheets)
+	xw.sheets = append(xw.sheets, name)
+	sheet, err := xw.create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(xw.sheets)))
+	if err != nil {
+		return err
+	}
+	xw.sheet, xw.row = sheet, 0
+	io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n"+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`+
+		`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`+
+		`<sheetData>`)
+
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		header[i] = col
+	}
+	return xw.writeRow(header, xlsxStyleHeader)
+}
+
+// WriteRow appends a row of values to the current sheet.
+func (xw *xlsxWriter) WriteRow(values []interface{}) error {
+	return xw.writeRow(values, 0)
+}
+
+func (xw *xlsxWriter) writeRow(values []interface{}, style int) error {
+	if xw.sheet == nil {
+		return errors.New("xlsx: no sheet started")
+	}
+	xw.row++
+	b := xw.cell[:0]
+	b = append(b, `<row r="`...)
+	b = strconv.AppendInt(b, int64(xw.row), 10)
+	b = append(b, `">`...)
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		b = xlsxAppendCell(b, xlsxCellRef(i, xw.row), v, style)
+	}
+	b = append(b, `</row>`...)
+	xw.cell = b
+	_, err := xw.sheet.Write(b)
+	return err
+}
+
+func (xw *xlsxWriter) endSheet() error {
+	if xw.sheet == nil {
+		return nil
+	}
+	_, err := io.WriteString(xw.sheet, `</sheetData></worksheet>`)
+	xw.sheet = nil
+	return err
+}
+
+// Close writes the workbook parts and the zip directory.
+func (xw *xlsxWriter) Close() error {
+	if len(xw.sheets) == 0 {
+		if err := xw.StartSheet("Sheet1", nil); err != nil {
+			return err
+		}
+	}
+	if err := xw.endSheet(); err != nil {
+		return err
+	}
+
+	var types, workbook, rels strings.Builder
+	types.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	workbook.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	rels.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i, name := range xw.sheets {
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(name), i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+	types.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`, len(xw.sheets)+1)
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", types.String()},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, part := range parts {
+		w, err := xw.create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, part.body); err != nil {
+			return err
+		}
+	}
+	return xw.zip.Close()
+}
+
+func (xw *xlsxWriter) create(name string) (io.Writer, error) {
+	return xw.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: xw.created})
+}
+
+// xlsxAppendCell encodes one cell. Integers beyond what a double holds
+// exactly are written as text so they survive a round trip.
+func xlsxAppendCell(b []byte, ref string, v interface{}, style int) []byte {
+	number := func(text string) []byte {
+		b = append(b, `<c r="`...)
+		b = append(b, ref...)
+		if style != 0 {
+			b = append(b, `" s="`...)
+			b = strconv.AppendInt(b, int64(style), 10)
+		}
+		b = append(b, `"><v>`...)
+		b = append(b, text...)
+		return append(b, `</v></c>`...)
+	}
+	switch val := v.(type) {
+	case int64:
+		if val < 1<<53 && val > -1<<53 {
+			return number(strconv.FormatInt(val, 10))
+		}
+	case float64:
+		if !math.IsNaN(val) && !math.IsInf(val, 0) {
+			return number(strconv.FormatFloat(val, 'g', -1, 64))
+		}
+	case time.Time:
+		if serial, ok := xlsxTimeSerial(val); ok && style == 0 {
+			style = xlsxStyleDateTime
+			if val.Hour() == 0 && val.Minute() == 0 && val.Second() == 0 && val.Nanosecond() == 0 {
+				style = xlsxStyleDate
+			}
+			return number(strconv.FormatFloat(serial, 'f', -1, 64))
+		}
+		v = val.Format(time.RFC3339Nano)
+	}
+
+	text := fmt.Sprint(v)
+	if utf8.RuneCountInString(text) > xlsxMaxCellText {
+		text = string([]rune(text)[:xlsxMaxCellText])
+	}
+	b = append(b, `<c r="`...)
+	b = append(b, ref...)
+	if style != 0 {
+		b = append(b, `" s="`...)
+		b = strconv.AppendInt(b, int64(style), 10)
+	}
+	b = append(b, `" t="inlineStr"><is><t xml:space="preserve">`...)
+	b = append(b, xmlEscape(text)...)
+	return append(b, `</t></is></c>`...)
+}
+
+// xlsxTimeSerial converts t to a serial number by its wall clock; the
+// offset is dropped since cells have no time zone. Dates before March 1900
+// are refused, since spreadsheets count a nonexistent 29 February 1900.
+func xlsxTimeSerial(t time.Time) (float64, bool) {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	if wall.Before(time.Date(1900, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		return 0, false
+	}
+	return wall.Sub(xlsxEpoch).Hours() / 24, true
+}
+
+// xlsxDateString formats a serial number shown in a format of the given
+// kind as SQLite's date functions write it. A time of day becomes
+// hh:mm:ss, counting hours past 24 for durations.
+func xlsxDateString(serial float64, kind int) string {
+	seconds := int64(math.Round(serial * 86400))
+	if kind == xlsxFormatTime {
+		if seconds < 0 {
+			seconds = 0
+		}
+		return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	t := xlsxEpoch.Add(time.Duration(seconds) * time.Second)
+	if kind == xlsxFormatDate || serial == math.Trunc(serial) {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// xlsxCellRef returns the A1 reference of a zero-based column and a
+// one-based row.
+func xlsxCellRef(col, row int) string {
+	return xlsxColumnName(col) + strconv.Itoa(row)
+}
+
+func xlsxColumnName(col int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxColumnIndex parses the column letters of an A1 reference.
+func xlsxColumnIndex(ref string) (int, bool) {
+	col := 0
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A') + 1
+		n++
+	}
+	return col - 1, n > 0
+}
+
+// xlsxSheetName makes name valid as a sheet name and distinct from taken.
+func xlsxSheetName(name string, taken []string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		name = "Sheet"
+	}
+	base := []rune(name)
+	if len(base) > xlsxMaxSheetName {
+		base = base[:xlsxMaxSheetName]
+	}
+	candidate := string(base)
+	for n := 2; ; n++ {
+		clash := false
+		for _, t := range taken {
+			if strings.EqualFold(t, candidate) {
+				clash = true
+				break
+			}
+		}
+		if !clash {
+			return candidate
+		}
+		suffix := fmt.Sprintf("~%d", n)
+		trimmed := base
+		if len(trimmed)+len(suffix) > xlsxMaxSheetName {
+			trimmed = trimmed[:xlsxMaxSheetName-len(suffix)]
+		}
+		candidate = string(trimmed) + suffix
+	}
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// xlsxRecords reads the rows of one sheet as import records. The first
+// row is the header unless header is false.
+type xlsxRecords struct {
+	dec       *xml.Decoder
+	strings   []string
+	dateStyle map[int]int // style index -> xlsxFormat kind
+	columns   []string
+	first     []interface{}
+}
+
+func newXLSXRecords(r io.ReaderAt, size int64, sheet string, header bool) (*xlsxRecords, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not an XLSX file: %w", err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	sheetPath, err := xlsxSheetPath(files, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	xr := &xlsxRecords{dateStyle: map[int]int{}}
+	if f := files["xl/sharedStrings.xml"]; f != nil {
+		if xr.strings, err = xlsxSharedStrings(f); err != nil {
+			return nil, fmt.Errorf("read shared strings: %w", err)
+		}
+	}
+	if f := files["xl/styles.xml"]; f != nil {
+		if xr.dateStyle, err = xlsxDateStyles(f); err != nil {
+			return nil, fmt.Errorf("read styles: %w", err)
+		}
+	}
+	f := files[sheetPath]
+	if f == nil {
+		return nil, fmt.Errorf("sheet part %s is missing", sheetPath)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	xr.dec = xml.NewDecoder(rc)
+
+	first, err := xr.readRow()
+	if errors.Is(err, io.EOF) {
+		return xr, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if header {
+		xr.columns = make([]string, len(first))
+		for i, v := range first {
+			if v != nil {
+				xr.columns[i] = strings.TrimSpace(fmt.Sprint(v))
+			}
+			if xr.columns[i] == "" {
+				xr.columns[i] = fmt.Sprintf("column%d", i+1)
+			}
+		}
+		return xr, nil
+	}
+	xr.columns = make([]string, len(first))
+	for i := range first {
+		xr.columns[i] = fmt.Sprintf("column%d", i+1)
+	}
+	xr.first = first
+	return xr, nil
+}
+
+func (xr *xlsxRecords) Columns() []string {
+	return xr.columns
+}
+
+func (xr *xlsxRecords) Next() ([]interface{}, error) {
+	if xr.first != nil {
+		rec := xr.first
+		xr.first = nil
+		return rec, nil
+	}
+	for {
+		rec, err := xr.readRow()
+		if err != nil {
+			return nil, err
+		}
+		// Rows past the header width belong to no column
+		if len(rec) > len(xr.columns) {
+			rec = rec[:len(xr.columns)]
+		}
+		for _, v := range rec {
+			if v != nil {
+				return rec, nil
+			}
+		}
+		// Skip rows that are empty, e.g. formatted but unused
+	}
+}
+
+// readRow decodes the next <row> element. Cells are placed by their
+// reference, so skipped cells read as NULL.
+func (xr *xlsxRecords) readRow() ([]interface{}, error) {
+	for {
+		tok, err := xr.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		var row struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Style  int    `xml:"s,attr"`
+				Value  string `xml:"v"`
+				Inline struct {
+					Text string `xml:"t"`
+					Runs []struct {
+						Text string `xml:"t"`
+					} `xml:"r"`
+				} `xml:"is"`
+			} `xml:"c"`
+		}
+		if err := xr.dec.DecodeElement(&row, &start); err != nil {
+			return nil, err
+		}
+		var rec []interface{}
+		for i, cell := range row.Cells {
+			col := i
+			if cell.Ref != "" {
+				if c, ok := xlsxColumnIndex(cell.Ref); ok {
+					col = c
+				}
+			}
+			for len(rec) <= col {
+				rec = append(rec, nil)
+			}
+			switch cell.Type {
+			case "s":
+				if idx, err := strconv.Atoi(cell.Value); err == nil && idx >= 0 && idx < len(xr.strings) {
+					rec[col] = xr.strings[idx]
+				}
+			case "inlineStr":
+				text := cell.Inline.Text
+				for _, run := range cell.Inline.Runs {
+					text += run.Text
+				}
+				rec[col] = text
+			case "str", "e":
+				rec[col] = cell.Value
+			case "b":
+				rec[col] = cell.Value == "1"
+			default:
+				if cell.Value == "" {
+					continue
+				}
+				kind, isDate := xr.dateStyle[cell.Style]
+				if serial, err := strconv.ParseFloat(cell.Value, 64); err == nil && isDate {
+					rec[col] = xlsxDateString(serial, kind)
+				} else {
+					rec[col] = json.Number(cell.Value)
+				}
+			}
+		}
+		return rec, nil
+	}
+}
+
+// xlsxSheetPath resolves a sheet name, or the first sheet when name is
+// empty, to its part in the package.
+func xlsxSheetPath(files map[string]*zip.File, name string) (string, error) {
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xlsxDecodePart(files["xl/workbook.xml"], &workbook); err != nil {
+		return "", fmt.Errorf("read workbook: %w", err)
+	}
+	var rels struct {
+		Items []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := xlsxDecodePart(files["xl/_rels/workbook.xml.rels"], &rels); err != nil {
+		return "", fmt.Errorf("read workbook relationships: %w", err)
+	}
+
+	var names []string
+	for _, sheet := range workbook.Sheets {
+		names = append(names, sheet.Name)
+		if name != "" && sheet.Name != name {
+			continue
+		}
+		for _, rel := range rels.Items {
+			if rel.ID != sheet.RID {
+				continue
+			}
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/"), nil
+			}
+			return path.Join("xl", rel.Target), nil
+		}
+		return "", fmt.Errorf("sheet %s has no part", sheet.Name)
+	}
+	if name == "" {
+		return "", errors.New("workbook has no sheets")
+	}
+	return "", fmt.Errorf("sheet %q not found (sheets: %s)", name, strings.Join(names, ", "))
+}
+
+func xlsxDecodePart(f *zip.File, v interface{}) error {
+	if f == nil {
+		return errors.New("part is missing")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+func xlsxSharedStrings(f *zip.File) ([]string, error) {
+	var sst struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := xlsxDecodePart(f, &sst); err != nil {
+		return nil, err
+	}
+	values := make([]string, len(sst.Items))
+	for i, si := range sst.Items {
+		text := si.Text
+		for _, run := range si.Runs {
+			text += run.Text
+		}
+		values[i] = text
+	}
+	return values, nil
+}
+
+// xlsxDateStyles finds the cell styles that format numbers as dates or
+// times, and which kind of format each uses.
+func xlsxDateStyles(f *zip.File) (map[int]int, error) {
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := xlsxDecodePart(f, &styles); err != nil {
+		return nil, err
+	}
+	custom := map[int]string{}
+	for _, nf := range styles.NumFmts {
+		custom[nf.ID] = nf.Code
+	}
+	dates := map[int]int{}
+	for i, xf := range styles.CellXfs {
+		id := xf.NumFmtID
+		switch {
+		case id >= 14 && id <= 17:
+			dates[i] = xlsxFormatDate
+		case id == 22:
+			dates[i] = xlsxFormatDateTime
+		case id >= 18 && id <= 21 || id >= 45 && id <= 47:
+			// h:mm AM/PM, h:mm:ss, mm:ss, [h]:mm:ss and the like
+			dates[i] = xlsxFormatTime
+		default:
+			if code, ok := custom[id]; ok {
+				if kind := xlsxDateFormat(code); kind != 0 {
+					dates[i] = kind
+				}
+			}
+		}
+	}
+	return dates, nil
+}
+
+// xlsxDateFormat reports the kind of a number format code that shows a
+// date or time, or zero, by looking for date and time tokens outside
+// quoted text and brackets.
+func xlsxDateFormat(code string) int {
+	hasDate, hasTime := false, false
+	inQuote, inBracket := false, false
+	for i := 0; i < len(code); i++ {
+		ch := code[i]
+		switch {
+		case ch == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case ch == '\\':
+			i++
+		case ch == '[':
+			inBracket = true
+		case ch == ']':
+			inBracket = false
+		case inBracket:
+		case strings.IndexByte("yYdD", ch) >= 0:
+			hasDate = true
+		case strings.IndexByte("hHsS", ch) >= 0:
+			hasTime = true
+		}
+	}
+	switch {
+	case hasDate && hasTime:
+		return xlsxFormatDateTime
+	case hasDate:
+		return xlsxFormatDate
+	case hasTime:
+		return xlsxFormatTime
+	}
+	return 0
+}
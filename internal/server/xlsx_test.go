@@ -0,0 +1,190 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readXLSX reads every record of the first sheet of a workbook.
+func readXLSX(t *testing.T, data []byte, header bool) ([]string, [][]interface{}) {
+	t.Helper()
+	xr, err := newXLSXRecords(bytes.NewReader(data), int64(len(data)), "", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]interface{}
+	for {
+		rec, err := xr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, rec)
+	}
+	return xr.Columns(), rows
+}
+
+func TestXLSXRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		row  []interface{}
+		want []interface{}
+	}{
+		{
+			name: "numbers",
+			row:  []interface{}{int64(42), 1.5, int64(1) << 60},
+			want: []interface{}{json.Number("42"), json.Number("1.5"), "1152921504606846976"},
+		},
+		{
+			name: "text and null",
+			row:  []interface{}{"a <b> & c", nil, "x"},
+			want: []interface{}{"a <b> & c", nil, "x"},
+		},
+		{
+			name: "date columns become date cells",
+			row:  []interface{}{time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 13, 14, 15, 0, time.UTC)},
+			want: []interface{}{"2024-02-29", "2024-02-29 13:14:15"},
+		},
+		{
+			name: "text that looks like a date stays text",
+			row:  []interface{}{"2024-02-29", "2024-02-29 13:14:15"},
+			want: []interface{}{"2024-02-29", "2024-02-29 13:14:15"},
+		},
+		{
+			name: "time before 1900 written as text",
+			row:  []interface{}{time.Date(1850, 1, 1, 0, 0, 0, 0, time.UTC)},
+			want: []interface{}{"1850-01-01T00:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			xw := newXLSXWriter(&buf)
+			columns := make([]string, len(tt.row))
+			for i := range columns {
+				columns[i] = xlsxColumnName(i)
+			}
+			if err := xw.StartSheet("data", columns); err != nil {
+				t.Fatal(err)
+			}
+			if err := xw.WriteRow(tt.row); err != nil {
+				t.Fatal(err)
+			}
+			if err := xw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			gotColumns, rows := readXLSX(t, buf.Bytes(), true)
+			if !reflect.DeepEqual(gotColumns, columns) {
+				t.Errorf("columns %q, want %q", gotColumns, columns)
+			}
+			if len(rows) != 1 {
+				t.Fatalf("got %d rows, want 1", len(rows))
+			}
+			got := rows[0]
+			for len(got) < len(tt.want) {
+				got = append(got, nil)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// buildXLSX packs a one-sheet workbook with the given cell formats and
+// sheet data.
+func buildXLSX(t *testing.T, numFmts string, cellXfs []int, sheetData string) []byte {
+	t.Helper()
+	var xfs strings.Builder
+	for _, id := range cellXfs {
+		xfs.WriteString(`<xf numFmtId="` + strconv.Itoa(id) + `"/>`)
+	}
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="S" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/styles.xml": `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<numFmts>` + numFmts + `</numFmts><cellXfs>` + xfs.String() + `</cellXfs></styleSheet>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+			sheetData + `</sheetData></worksheet>`,
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestXLSXImportDateFormats(t *testing.T) {
+	// 45351.5 is 2024-02-29 12:00; 0.75 is 18:00; 1.5 is a day and a half
+	tests := []struct {
+		name    string
+		numFmts string
+		numFmt  int
+		value   string
+		want    interface{}
+	}{
+		{name: "general", numFmt: 0, value: "45351.5", want: json.Number("45351.5")},
+		{name: "built-in date", numFmt: 14, value: "45351.5", want: "2024-02-29"},
+		{name: "built-in date and time", numFmt: 22, value: "45351.5", want: "2024-02-29 12:00:00"},
+		{name: "built-in date and time on midnight", numFmt: 22, value: "45351", want: "2024-02-29"},
+		{name: "h:mm AM/PM", numFmt: 18, value: "0.75", want: "18:00:00"},
+		{name: "h:mm:ss AM/PM", numFmt: 19, value: "0.75", want: "18:00:00"},
+		{name: "h:mm", numFmt: 20, value: "0.5", want: "12:00:00"},
+		{name: "h:mm:ss", numFmt: 21, value: "0.000011574", want: "00:00:01"},
+		{name: "elapsed hours", numFmt: 46, value: "1.5", want: "36:00:00"},
+		{
+			name:    "custom date",
+			numFmts: `<numFmt numFmtId="164" formatCode="dd/mm/yyyy"/>`,
+			numFmt:  164,
+			value:   "45351.5",
+			want:    "2024-02-29",
+		},
+		{
+			name:    "custom time",
+			numFmts: `<numFmt numFmtId="164" formatCode="hh:mm:ss"/>`,
+			numFmt:  164,
+			value:   "0.75",
+			want:    "18:00:00",
+		},
+		{
+			name:    "quoted letters are not tokens",
+			numFmts: `<numFmt numFmtId="164" formatCode="0.00 &quot;days&quot;"/>`,
+			numFmt:  164,
+			value:   "1.5",
+			want:    json.Number("1.5"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildXLSX(t, tt.numFmts, []int{0, tt.numFmt}, `<row r="1"><c r="A1" s="1"><v>`+tt.value+`</v></c></row>`)
+			_, rows := readXLSX(t, data, false)
+			if len(rows) != 1 || len(rows[0]) != 1 {
+				t.Fatalf("got %#v, want one cell", rows)
+			}
+			if rows[0][0] != tt.want {
+				t.Errorf("got %#v, want %#v", rows[0][0], tt.want)
+			}
+		})
+	}
+}